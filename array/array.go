@@ -252,7 +252,10 @@ func MaxColValues(array [][]float64) []float64 {
 	cols := len(array[0])
 	minVal := -math.MaxFloat64
 
-	maxValues := []float64{minVal, minVal, minVal}
+	maxValues := make([]float64, cols)
+	for col := range maxValues {
+		maxValues[col] = minVal
+	}
 
 	for col := 0; col < cols; col++ {
 		for row := 0; row < rows; row++ {
@@ -268,7 +271,10 @@ func MinColValues(array [][]float64) []float64 {
 	cols := len(array[0])
 	maxVal := math.MaxFloat64
 
-	minValues := []float64{maxVal, maxVal, maxVal}
+	minValues := make([]float64, cols)
+	for col := range minValues {
+		minValues[col] = maxVal
+	}
 
 	for col := 0; col < cols; col++ {
 		for row := 0; row < rows; row++ {
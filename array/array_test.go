@@ -206,12 +206,16 @@ func TestMaxColValues(t *testing.T) {
 	array := [][]float64{{12, 23, 45}, {78, 45, 12}, {23, 24, 12}, {19, 57, 24}}
 	result := MaxColValues(array)
 	assert.Equal(t, result, []float64{78, 57, 45}, "MaxColValues result incorrect")
+	result = MaxColValues([][]float64{{1, 5}, {3, 2}})
+	assert.Equal(t, result, []float64{3, 5}, "MaxColValues result incorrect for 2 columns")
 }
 
 func TestMinColValues(t *testing.T) {
 	array := [][]float64{{12, 26, 45}, {78, 45, 42}, {23, 24, 37}, {19, 57, 29}}
 	result := MinColValues(array)
 	assert.Equal(t, result, []float64{12, 24, 29}, "MinColValues result incorrect")
+	result = MinColValues([][]float64{{1, 5, 7, 2}, {3, 2, 0, 4}})
+	assert.Equal(t, result, []float64{1, 2, 0, 2}, "MinColValues result incorrect for 4 columns")
 }
 
 func TestMaxValue(t *testing.T) {
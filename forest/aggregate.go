@@ -0,0 +1,125 @@
+package forest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Aggregation sets how the scores of the trees are combined into the score of the forest
+type Aggregation int
+
+const (
+	// AggregateMean takes the mean of the tree scores
+	AggregateMean Aggregation = iota
+	// AggregateMedian takes the median of the tree scores
+	AggregateMedian
+	// AggregateTrimmedMean takes the mean of the tree scores, dropping a proportion
+	// of the lowest and highest scores
+	AggregateTrimmedMean
+	// AggregateMax takes the highest tree score
+	AggregateMax
+	// AggregateQuantile takes a quantile of the tree scores
+	AggregateQuantile
+)
+
+// AggregationConfig configures how the scores of the trees are combined
+type AggregationConfig struct {
+	Method     Aggregation // Method combining the tree scores
+	Trim       float64     // Proportion of the scores dropped from each end, below 0.5, for AggregateTrimmedMean
+	Quantile   float64     // Quantile of the scores, from 0 to 1, for AggregateQuantile
+	TreeScores bool        // Whether results carry the score from each tree and their variance
+}
+
+// SetAggregation sets how the scores of the trees are combined into the score of
+// the forest, and whether results carry the score from each tree
+func (f *Forest[K]) SetAggregation(config AggregationConfig) error {
+	switch {
+	case config.Method == AggregateTrimmedMean && (config.Trim < 0 || config.Trim >= 0.5):
+		return fmt.Errorf("%w: trim (%v)", ErrInvalidParameter, config.Trim)
+	case config.Method == AggregateQuantile && (config.Quantile < 0 || config.Quantile > 1):
+		return fmt.Errorf("%w: quantile (%v)", ErrInvalidParameter, config.Quantile)
+	case config.Method < AggregateMean || config.Method > AggregateQuantile:
+		return fmt.Errorf("%w: aggregation (%d)", ErrInvalidParameter, config.Method)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.aggregation = config
+	return nil
+}
+
+// aggregate sets the score of a result from the tree scores, in place of their mean,
+// and records the tree scores if required
+func (f *Forest[K]) aggregate(result *Result, scores []float64) {
+	if f.aggregation.Method != AggregateMean {
+		result.scale(f.aggregation.aggregate(scores))
+	}
+	if f.aggregation.TreeScores {
+		result.TreeScores = scores
+		result.Variance = variance(scores)
+	}
+}
+
+// aggregate combines the tree scores, or returns 0 if there are none
+func (config AggregationConfig) aggregate(scores []float64) float64 {
+	if len(scores) == 0 {
+		return 0
+	}
+	switch config.Method {
+	case AggregateMedian:
+		return quantile(scores, 0.5)
+	case AggregateTrimmedMean:
+		sorted := sortedCopy(scores)
+		trim := int(config.Trim * float64(len(sorted)))
+		return mean(sorted[trim : len(sorted)-trim])
+	case AggregateMax:
+		highest := math.Inf(-1)
+		for _, score := range scores {
+			highest = math.Max(highest, score)
+		}
+		return highest
+	case AggregateQuantile:
+		return quantile(scores, config.Quantile)
+	}
+	return mean(scores)
+}
+
+// mean returns the mean of the scores
+func mean(scores []float64) float64 {
+	var sum float64
+	for _, score := range scores {
+		sum += score
+	}
+	return sum / float64(len(scores))
+}
+
+// variance returns the sample variance of the scores, or 0 for fewer than two scores
+func variance(scores []float64) float64 {
+	if len(scores) < 2 {
+		return 0
+	}
+	average := mean(scores)
+	var sum float64
+	for _, score := range scores {
+		sum += (score - average) * (score - average)
+	}
+	return sum / float64(len(scores)-1)
+}
+
+// quantile returns the quantile q of the scores, interpolating linearly between them
+func quantile(scores []float64, q float64) float64 {
+	sorted := sortedCopy(scores)
+	position := q * float64(len(sorted)-1)
+	lower := int(position)
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (position-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+func sortedCopy(scores []float64) []float64 {
+	sorted := append([]float64(nil), scores...)
+	sort.Float64s(sorted)
+	return sorted
+}
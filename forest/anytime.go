@@ -0,0 +1,110 @@
+package forest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+
+	"github.com/andysgithub/go-rrcf/random"
+)
+
+// defaultMinTrees is the number of trees scored before anytime scoring may stop, if not set
+const defaultMinTrees = 5
+
+// AnytimeConfig configures scoring a point with as few trees as needed to decide
+// whether it is anomalous
+type AnytimeConfig struct {
+	Threshold  float64 // Score above which a point is anomalous
+	Confidence float64 // Confidence required in the decision, between 0 and 1, such as 0.95
+	MinTrees   int     // Number of trees scored before stopping, at least 2, or 5 if 0
+	MaxTrees   int     // Maximum number of trees scored, or all trees if 0
+}
+
+// AnytimeResult records the score of a point from as few trees as needed
+type AnytimeResult struct {
+	Score     float64 // Mean score over the trees used, normalized if set
+	Trees     int     // Number of trees scored
+	Anomalous bool    // Whether the score is above the threshold
+	Decided   bool    // Whether the decision was reached with the required confidence
+}
+
+// ScorePointAnytime scores a point as ScorePoint does, taking the trees in random
+// order and stopping once the decision against the threshold is clear
+//
+// After MinTrees trees, scoring stops as soon as the confidence interval for the mean
+// score over all trees lies wholly above or below the threshold. Each bound of the
+// interval holds with the given confidence, from the standard error of the scores
+// so far. Otherwise scoring stops after MaxTrees trees, and the result is not decided.
+// The score is the mean of the tree scores, whatever the aggregation set, normalized
+// if set. The order of the trees is drawn from the point, so a point is always scored
+// the same way and no random state is changed.
+func (f *Forest[K]) ScorePointAnytime(point []float64, config AnytimeConfig) (AnytimeResult, error) {
+	if config.Confidence <= 0 || config.Confidence >= 1 || config.MinTrees == 1 || config.MinTrees < 0 || config.MaxTrees < 0 {
+		return AnytimeResult{}, fmt.Errorf("%w: confidence (%v), minimum trees (%d), maximum trees (%d)",
+			ErrInvalidParameter, config.Confidence, config.MinTrees, config.MaxTrees)
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	point, err := f.scoringPoint(point)
+	if point == nil || err != nil {
+		return AnytimeResult{}, err
+	}
+	scorer, err := f.pointScorer()
+	if err != nil {
+		return AnytimeResult{}, err
+	}
+
+	maxTrees := config.MaxTrees
+	if maxTrees == 0 || maxTrees > f.NumTrees {
+		maxTrees = f.NumTrees
+	}
+	minTrees := config.MinTrees
+	if minTrees == 0 {
+		minTrees = defaultMinTrees
+	}
+	z := math.Sqrt2 * math.Erfinv(2*config.Confidence-1)
+
+	order := make([]int, f.NumTrees)
+	for i := range order {
+		order[i] = i
+	}
+	order = random.NewRandomState(pointSeed(point)).Shuffle(order)
+
+	// Keep the running mean and sum of squared differences of the tree scores
+	var result AnytimeResult
+	var mean, m2 float64
+	for _, treeIndex := range order[:maxTrees] {
+		score, err := scorer.ScorePoint(&f.Trees[treeIndex], point)
+		if err != nil {
+			return AnytimeResult{}, err
+		}
+		result.Trees++
+		delta := score - mean
+		mean += delta / float64(result.Trees)
+		m2 += delta * (score - mean)
+
+		if result.Trees >= minTrees {
+			margin := z * math.Sqrt(m2/float64(result.Trees-1)/float64(result.Trees))
+			if f.normalizedScore(mean-margin) > config.Threshold || f.normalizedScore(mean+margin) < config.Threshold {
+				result.Decided = true
+				break
+			}
+		}
+	}
+	result.Score = f.normalizedScore(mean)
+	result.Anomalous = result.Score > config.Threshold
+	return result, nil
+}
+
+// pointSeed returns a seed drawn from the values of a point
+func pointSeed(point []float64) int64 {
+	hash := fnv.New64a()
+	var buffer [8]byte
+	for _, value := range point {
+		binary.LittleEndian.PutUint64(buffer[:], math.Float64bits(value))
+		hash.Write(buffer[:])
+	}
+	return int64(hash.Sum64() >> 1)
+}
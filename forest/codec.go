@@ -0,0 +1,565 @@
+package forest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/andysgithub/go-rrcf/rrcf"
+)
+
+// ForestBinaryVersion is the version of the binary forest snapshot format written by
+// WriteForest
+//
+// A forest snapshot starts with the magic bytes "RRCS" and the format version as a
+// uvarint, followed by a single frame holding the streaming state of the forest: its
+// uvarint length, the payload and its CRC-32 (Castagnoli) checksum as 4 little-endian
+// bytes. The trees follow as an rrcf binary snapshot, as written by rrcf.WriteForest.
+//
+// The streaming state payload holds the fields of ForestState other than the trees,
+// in order. Integers are varints, floats are raw 8-byte little-endian values, and
+// strings, byte strings and lists are preceded by their uvarint length. Optional
+// values are preceded by a byte of 1 if present or 0 if not, and times are encoded
+// by time.Time.MarshalBinary.
+const ForestBinaryVersion = 1
+
+// maxStateLength limits the size of the streaming state payload when decoding
+const maxStateLength = 1 << 30
+
+var (
+	forestMagic = []byte("RRCS")
+	crcTable    = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// WriteForest writes a forest with integer labels to w as a binary snapshot,
+// holding its trees and streaming state
+func WriteForest(w io.Writer, f *Forest[int]) error {
+	state, err := f.State()
+	if err != nil {
+		return err
+	}
+	payload := appendForestState(nil, state)
+	header := binary.AppendUvarint(append([]byte{}, forestMagic...), ForestBinaryVersion)
+	header = binary.AppendUvarint(header, uint64(len(payload)))
+	header = append(header, payload...)
+	header = binary.LittleEndian.AppendUint32(header, crc32.Checksum(payload, crcTable))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	enc := rrcf.NewEncoder(w)
+	for _, treeState := range state.Trees {
+		if err := enc.EncodeState(treeState); err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
+
+// ReadForest reads a forest from a binary snapshot written by WriteForest
+// Truncated, corrupt or incompatible snapshots are reported with the errors of the
+// rrcf binary snapshot format, such as rrcf.ErrChecksum.
+func ReadForest(r io.Reader) (*Forest[int], error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(forestMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, rrcf.ErrNotSnapshot
+		}
+		return nil, err
+	}
+	if !bytes.Equal(magic, forestMagic) {
+		return nil, rrcf.ErrNotSnapshot
+	}
+	version, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if version != ForestBinaryVersion {
+		return nil, fmt.Errorf("%w: %d", rrcf.ErrSnapshotVersion, version)
+	}
+	length, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if length > maxStateLength {
+		return nil, fmt.Errorf("%w: frame length %d", rrcf.ErrCorruptSnapshot, length)
+	}
+	frame := make([]byte, length+4)
+	if _, err := io.ReadFull(br, frame); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	payload := frame[:length]
+	if binary.LittleEndian.Uint32(frame[length:]) != crc32.Checksum(payload, crcTable) {
+		return nil, rrcf.ErrChecksum
+	}
+	state, err := readForestState(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := rrcf.NewDecoder(br)
+	for {
+		treeState, err := dec.DecodeState()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		state.Trees = append(state.Trees, treeState)
+	}
+	forest, err := NewForestFromState(state)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", rrcf.ErrCorruptSnapshot, err)
+	}
+	return forest, nil
+}
+
+// SaveForestBinary saves a forest as a binary snapshot to the specified file
+func SaveForestBinary(f *Forest[int], filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	if err := WriteForest(w, f); err != nil {
+		file.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// LoadForestBinary loads a forest from a binary snapshot saved by SaveForestBinary
+func LoadForestBinary(filename string) (*Forest[int], error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadForest(file)
+}
+
+// unexpectedEOF reports the end of data in the middle of a snapshot as io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// stateWriter appends values to a streaming state payload
+type stateWriter struct {
+	b []byte
+}
+
+func (sw *stateWriter) uvarint(value int) {
+	sw.b = binary.AppendUvarint(sw.b, uint64(value))
+}
+
+func (sw *stateWriter) varint(value int64) {
+	sw.b = binary.AppendVarint(sw.b, value)
+}
+
+func (sw *stateWriter) float(value float64) {
+	sw.b = binary.LittleEndian.AppendUint64(sw.b, math.Float64bits(value))
+}
+
+func (sw *stateWriter) floats(values []float64) {
+	sw.uvarint(len(values))
+	for _, value := range values {
+		sw.float(value)
+	}
+}
+
+func (sw *stateWriter) ints(values []int) {
+	sw.uvarint(len(values))
+	for _, value := range values {
+		sw.varint(int64(value))
+	}
+}
+
+func (sw *stateWriter) bytes(value []byte) {
+	sw.uvarint(len(value))
+	sw.b = append(sw.b, value...)
+}
+
+func (sw *stateWriter) bool(value bool) {
+	if value {
+		sw.b = append(sw.b, 1)
+	} else {
+		sw.b = append(sw.b, 0)
+	}
+}
+
+func (sw *stateWriter) time(value time.Time) {
+	data, _ := value.MarshalBinary()
+	sw.bytes(data)
+}
+
+// appendForestState appends the binary payload for the streaming state of a forest
+func appendForestState(b []byte, state ForestState[int]) []byte {
+	sw := stateWriter{b}
+	sw.uvarint(state.NumTrees)
+	sw.uvarint(state.TreeSize)
+	sw.uvarint(state.DataPoints)
+	sw.uvarint(state.ShingleSize)
+	sw.varint(int64(state.Workers))
+	sw.bytes(state.Rng)
+
+	sw.bytes([]byte(state.Sampling))
+	sw.float(state.Decay)
+	sw.uvarint(len(state.Samplers))
+	for _, sampler := range state.Samplers {
+		sw.bytes([]byte(sampler.Kind))
+		sw.bytes(sampler.Rng)
+		sw.float(sampler.Decay)
+		sw.uvarint(sampler.Seen)
+		sw.ints(sampler.Members)
+		sw.floats(sampler.Keys)
+		sw.float(sampler.Next)
+	}
+
+	sw.bytes([]byte(state.Scorer))
+	sw.varint(int64(state.MinMass))
+	sw.uvarint(int(state.Aggregation.Method))
+	sw.float(state.Aggregation.Trim)
+	sw.float(state.Aggregation.Quantile)
+	sw.bool(state.Aggregation.TreeScores)
+	sw.uvarint(int(state.Normalization))
+	sw.uvarint(state.History)
+	sw.floats(state.Scores)
+
+	sw.bool(state.Thresholder != nil)
+	if th := state.Thresholder; th != nil {
+		sw.uvarint(int(th.Config.Rule))
+		sw.float(th.Config.Percentile)
+		sw.float(th.Config.ZScore)
+		sw.uvarint(th.Config.WarmUp)
+		sw.uvarint(th.Config.Window)
+		sw.uvarint(th.Seen)
+		for _, block := range th.Blocks {
+			sw.bool(block != nil)
+			if block != nil {
+				sw.uvarint(block.Count)
+				sw.float(block.Mean)
+				sw.float(block.M2)
+				for _, markers := range [][5]float64{block.Heights, block.Positions, block.Desired} {
+					for _, value := range markers {
+						sw.float(value)
+					}
+				}
+			}
+		}
+	}
+	sw.bool(state.Episodes != nil)
+	if tr := state.Episodes; tr != nil {
+		sw.float(tr.Config.Enter)
+		sw.float(tr.Config.Exit)
+		sw.uvarint(tr.Config.MinDuration)
+		sw.uvarint(tr.Config.Cooldown)
+		sw.bool(tr.Current != nil)
+		if episode := tr.Current; episode != nil {
+			sw.varint(int64(episode.Start))
+			sw.varint(int64(episode.Peak))
+			sw.varint(int64(episode.End))
+			sw.float(episode.PeakScore)
+			sw.uvarint(episode.Points)
+		}
+		sw.bool(tr.Alerted)
+		sw.bool(tr.Suppress)
+		sw.varint(int64(tr.LastEnd))
+		sw.bool(tr.HasEnded)
+	}
+
+	sw.uvarint(int(state.ShingleMode))
+	sw.floats(state.Shingle)
+	sw.uvarint(state.ShingleCount)
+	sw.uvarint(state.ShingleDims)
+	sw.uvarint(state.Updates)
+
+	sw.bool(state.Window != nil)
+	if window := state.Window; window != nil {
+		sw.varint(int64(window.Duration))
+		sw.uvarint(window.MaxPoints)
+		sw.varint(int64(window.Lateness))
+		sw.varint(int64(window.MaxGap))
+		sw.varint(int64(window.Interval))
+		sw.uvarint(int(window.Gaps))
+	}
+	sw.uvarint(len(state.Values))
+	for i, value := range state.Values {
+		sw.time(state.ValueTimes[i])
+		sw.float(value)
+	}
+	// Event times are written in label order so that identical forests give identical snapshots
+	labels := make([]int, 0, len(state.Times))
+	for label := range state.Times {
+		labels = append(labels, label)
+	}
+	sort.Ints(labels)
+	sw.uvarint(len(labels))
+	for _, label := range labels {
+		sw.varint(int64(label))
+		sw.time(state.Times[label])
+	}
+	sw.uvarint(len(state.Timeline))
+	for i, label := range state.Timeline {
+		sw.varint(int64(label))
+		sw.time(state.TimelineTimes[i])
+	}
+	sw.ints(state.Untimed)
+	sw.time(state.Latest)
+	return sw.b
+}
+
+// stateReader decodes values from a streaming state payload, recording the first error
+type stateReader struct {
+	data []byte
+	err  error
+}
+
+func (sr *stateReader) fail() {
+	if sr.err == nil {
+		sr.err = rrcf.ErrCorruptSnapshot
+	}
+}
+
+func (sr *stateReader) uvarint() int {
+	if sr.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(sr.data)
+	if n <= 0 || value > math.MaxInt {
+		sr.fail()
+		return 0
+	}
+	sr.data = sr.data[n:]
+	return int(value)
+}
+
+func (sr *stateReader) varint() int64 {
+	if sr.err != nil {
+		return 0
+	}
+	value, n := binary.Varint(sr.data)
+	if n <= 0 {
+		sr.fail()
+		return 0
+	}
+	sr.data = sr.data[n:]
+	return value
+}
+
+// length reads a count, checking that at least size bytes remain for each item
+func (sr *stateReader) length(size int) int {
+	value := sr.uvarint()
+	if sr.err == nil && value > len(sr.data)/size {
+		sr.fail()
+		return 0
+	}
+	return value
+}
+
+func (sr *stateReader) float() float64 {
+	if sr.err != nil {
+		return 0
+	}
+	if len(sr.data) < 8 {
+		sr.fail()
+		return 0
+	}
+	value := math.Float64frombits(binary.LittleEndian.Uint64(sr.data))
+	sr.data = sr.data[8:]
+	return value
+}
+
+func (sr *stateReader) floats() []float64 {
+	n := sr.length(8)
+	if sr.err != nil || n == 0 {
+		return nil
+	}
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = sr.float()
+	}
+	return values
+}
+
+func (sr *stateReader) ints() []int {
+	n := sr.length(1)
+	if sr.err != nil || n == 0 {
+		return nil
+	}
+	values := make([]int, n)
+	for i := range values {
+		values[i] = int(sr.varint())
+	}
+	return values
+}
+
+func (sr *stateReader) bytes() []byte {
+	n := sr.length(1)
+	if sr.err != nil || n == 0 {
+		return nil
+	}
+	value := append([]byte{}, sr.data[:n]...)
+	sr.data = sr.data[n:]
+	return value
+}
+
+func (sr *stateReader) bool() bool {
+	if sr.err != nil {
+		return false
+	}
+	if len(sr.data) < 1 || sr.data[0] > 1 {
+		sr.fail()
+		return false
+	}
+	value := sr.data[0] == 1
+	sr.data = sr.data[1:]
+	return value
+}
+
+func (sr *stateReader) time() time.Time {
+	var value time.Time
+	if data := sr.bytes(); sr.err == nil {
+		if err := value.UnmarshalBinary(data); err != nil {
+			sr.fail()
+		}
+	}
+	return value
+}
+
+// readForestState decodes the binary payload for the streaming state of a forest
+func readForestState(payload []byte) (ForestState[int], error) {
+	sr := stateReader{data: payload}
+	state := ForestState[int]{Version: ForestStateVersion}
+	state.NumTrees = sr.uvarint()
+	state.TreeSize = sr.uvarint()
+	state.DataPoints = sr.uvarint()
+	state.ShingleSize = sr.uvarint()
+	state.Workers = int(sr.varint())
+	state.Rng = sr.bytes()
+
+	state.Sampling = string(sr.bytes())
+	state.Decay = sr.float()
+	numSamplers := sr.length(1)
+	for i := 0; i < numSamplers && sr.err == nil; i++ {
+		var sampler SamplerState[int]
+		sampler.Kind = string(sr.bytes())
+		sampler.Rng = sr.bytes()
+		sampler.Decay = sr.float()
+		sampler.Seen = sr.uvarint()
+		sampler.Members = sr.ints()
+		sampler.Keys = sr.floats()
+		sampler.Next = sr.float()
+		state.Samplers = append(state.Samplers, sampler)
+	}
+
+	state.Scorer = string(sr.bytes())
+	state.MinMass = int(sr.varint())
+	state.Aggregation.Method = Aggregation(sr.uvarint())
+	state.Aggregation.Trim = sr.float()
+	state.Aggregation.Quantile = sr.float()
+	state.Aggregation.TreeScores = sr.bool()
+	state.Normalization = Normalization(sr.uvarint())
+	state.History = sr.uvarint()
+	state.Scores = sr.floats()
+
+	if sr.bool() {
+		th := &ThresholderState{}
+		th.Config.Rule = ThresholdRule(sr.uvarint())
+		th.Config.Percentile = sr.float()
+		th.Config.ZScore = sr.float()
+		th.Config.WarmUp = sr.uvarint()
+		th.Config.Window = sr.uvarint()
+		th.Seen = sr.uvarint()
+		for i := range th.Blocks {
+			if sr.bool() {
+				block := &ThresholdBlockState{Count: sr.uvarint(), Mean: sr.float(), M2: sr.float()}
+				for _, markers := range []*[5]float64{&block.Heights, &block.Positions, &block.Desired} {
+					for j := range markers {
+						markers[j] = sr.float()
+					}
+				}
+				th.Blocks[i] = block
+			}
+		}
+		state.Thresholder = th
+	}
+	if sr.bool() {
+		tr := &EpisodeState{}
+		tr.Config.Enter = sr.float()
+		tr.Config.Exit = sr.float()
+		tr.Config.MinDuration = sr.uvarint()
+		tr.Config.Cooldown = sr.uvarint()
+		if sr.bool() {
+			tr.Current = &Episode{
+				Start:     int(sr.varint()),
+				Peak:      int(sr.varint()),
+				End:       int(sr.varint()),
+				PeakScore: sr.float(),
+				Points:    sr.uvarint(),
+			}
+		}
+		tr.Alerted = sr.bool()
+		tr.Suppress = sr.bool()
+		tr.LastEnd = int(sr.varint())
+		tr.HasEnded = sr.bool()
+		state.Episodes = tr
+	}
+
+	state.ShingleMode = ShingleMode(sr.uvarint())
+	state.Shingle = sr.floats()
+	state.ShingleCount = sr.uvarint()
+	state.ShingleDims = sr.uvarint()
+	state.Updates = sr.uvarint()
+
+	if sr.bool() {
+		state.Window = &TimeWindow{
+			Duration:  time.Duration(sr.varint()),
+			MaxPoints: sr.uvarint(),
+			Lateness:  time.Duration(sr.varint()),
+			MaxGap:    time.Duration(sr.varint()),
+			Interval:  time.Duration(sr.varint()),
+			Gaps:      GapPolicy(sr.uvarint()),
+		}
+	}
+	numValues := sr.length(9)
+	for i := 0; i < numValues && sr.err == nil; i++ {
+		state.ValueTimes = append(state.ValueTimes, sr.time())
+		state.Values = append(state.Values, sr.float())
+	}
+	numTimes := sr.length(2)
+	if numTimes > 0 {
+		state.Times = make(map[int]time.Time, numTimes)
+	}
+	for i := 0; i < numTimes && sr.err == nil; i++ {
+		label := int(sr.varint())
+		state.Times[label] = sr.time()
+	}
+	numTimeline := sr.length(2)
+	for i := 0; i < numTimeline && sr.err == nil; i++ {
+		state.Timeline = append(state.Timeline, int(sr.varint()))
+		state.TimelineTimes = append(state.TimelineTimes, sr.time())
+	}
+	state.Untimed = sr.ints()
+	state.Latest = sr.time()
+
+	if sr.err == nil && len(sr.data) > 0 {
+		sr.fail()
+	}
+	return state, sr.err
+}
@@ -0,0 +1,157 @@
+package forest
+
+import (
+	"fmt"
+)
+
+// EpisodeConfig configures an EpisodeTracker
+type EpisodeConfig struct {
+	Enter       float64 // Score at or above which an episode starts
+	Exit        float64 // Score below which an episode ends, no greater than Enter
+	MinDuration int     // Number of points an episode must last before it is alerted
+	Cooldown    int     // Number of samples after an alerted episode ends in which new episodes are not alerted
+}
+
+// EpisodeEventType identifies the change in an episode reported by an event
+type EpisodeEventType int
+
+const (
+	// EpisodeStarted reports that an episode has lasted MinDuration points and is alerted
+	EpisodeStarted EpisodeEventType = iota
+	// EpisodeEnded reports the end of an alerted episode
+	EpisodeEnded
+)
+
+// String returns the name of the event type
+func (eventType EpisodeEventType) String() string {
+	switch eventType {
+	case EpisodeStarted:
+		return "started"
+	case EpisodeEnded:
+		return "ended"
+	}
+	return fmt.Sprintf("EpisodeEventType(%d)", int(eventType))
+}
+
+// MarshalText encodes the event type by name, for logging events as json
+func (eventType EpisodeEventType) MarshalText() ([]byte, error) {
+	return []byte(eventType.String()), nil
+}
+
+// Episode records a run of consecutive high-scoring points
+// In a forest with labels that are not integers, points are indexed by their position
+// in the stream
+type Episode struct {
+	Start     int     `json:"start"`      // Sample index of the first point
+	Peak      int     `json:"peak"`       // Sample index of the highest-scoring point
+	End       int     `json:"end"`        // Sample index of the last point so far
+	PeakScore float64 `json:"peak_score"` // Highest score in the episode
+	Points    int     `json:"points"`     // Number of points in the episode so far
+}
+
+// EpisodeEvent reports a change in an episode
+type EpisodeEvent struct {
+	Type    EpisodeEventType `json:"type"`    // Change in the episode
+	Index   int              `json:"index"`   // Sample index of the point causing the event
+	Episode Episode          `json:"episode"` // Episode as at the event
+}
+
+// EpisodeTracker groups the points of a stream into anomaly episodes
+//
+// An episode starts at a score of at least Enter and continues until a score falls
+// below Exit, so that a score wavering about a single threshold does not start and
+// end episodes repeatedly. An episode is alerted with an EpisodeStarted event once it
+// has lasted MinDuration points, unless it started within Cooldown samples of the
+// end of the last alerted episode. An EpisodeEnded event follows when an alerted
+// episode ends. Episodes that are not alerted produce no events.
+type EpisodeTracker struct {
+	config   EpisodeConfig
+	current  *Episode // Episode in progress, if any
+	alerted  bool     // Whether the episode in progress has been alerted
+	suppress bool     // Whether the episode in progress started within the cooldown
+	lastEnd  int      // Sample index at the end of the last alerted episode
+	hasEnded bool     // Whether an alerted episode has ended
+}
+
+// NewEpisodeTracker creates an EpisodeTracker with the given configuration
+func NewEpisodeTracker(config EpisodeConfig) (*EpisodeTracker, error) {
+	if config.Exit > config.Enter || config.MinDuration < 0 || config.Cooldown < 0 {
+		return nil, fmt.Errorf("%w: enter (%v), exit (%v), minimum duration (%d), cooldown (%d)",
+			ErrInvalidParameter, config.Enter, config.Exit, config.MinDuration, config.Cooldown)
+	}
+	return &EpisodeTracker{config: config}, nil
+}
+
+// Observe adds the score of the next point in the stream, and returns any events
+// caused by the point
+func (tr *EpisodeTracker) Observe(sampleIndex int, score float64) []EpisodeEvent {
+	var events []EpisodeEvent
+	if tr.current != nil && score < tr.config.Exit {
+		events = tr.end(sampleIndex)
+	}
+
+	if tr.current == nil {
+		if score < tr.config.Enter {
+			return events
+		}
+		tr.current = &Episode{Start: sampleIndex, Peak: sampleIndex, PeakScore: score}
+		tr.alerted = false
+		tr.suppress = tr.hasEnded && sampleIndex-tr.lastEnd <= tr.config.Cooldown
+	}
+
+	episode := tr.current
+	episode.End = sampleIndex
+	episode.Points++
+	if score > episode.PeakScore {
+		episode.Peak = sampleIndex
+		episode.PeakScore = score
+	}
+	if !tr.alerted && !tr.suppress && episode.Points >= tr.config.MinDuration {
+		tr.alerted = true
+		events = append(events, EpisodeEvent{Type: EpisodeStarted, Index: sampleIndex, Episode: *episode})
+	}
+	return events
+}
+
+// Close ends any episode in progress at the end of a stream, and returns its
+// EpisodeEnded event if it was alerted
+func (tr *EpisodeTracker) Close() []EpisodeEvent {
+	if tr.current == nil {
+		return nil
+	}
+	return tr.end(tr.current.End)
+}
+
+// Current returns the episode in progress, if any
+func (tr *EpisodeTracker) Current() (Episode, bool) {
+	if tr.current == nil {
+		return Episode{}, false
+	}
+	return *tr.current, true
+}
+
+// end ends the episode in progress
+func (tr *EpisodeTracker) end(sampleIndex int) []EpisodeEvent {
+	episode := *tr.current
+	tr.current = nil
+	if !tr.alerted {
+		return nil
+	}
+	tr.lastEnd = episode.End
+	tr.hasEnded = true
+	return []EpisodeEvent{{Type: EpisodeEnded, Index: sampleIndex, Episode: episode}}
+}
+
+// SetEpisodes attaches an EpisodeTracker to the forest, so that the scores from
+// UpdateResult are grouped into episodes and the results carry the episode events
+func (f *Forest[K]) SetEpisodes(config EpisodeConfig) error {
+	tracker, err := NewEpisodeTracker(config)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.episodes = tracker
+	return nil
+}
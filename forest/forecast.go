@@ -0,0 +1,101 @@
+package forest
+
+import (
+	"fmt"
+	"math"
+)
+
+// Forecast records the predicted value of a single-valued stream at one step ahead
+type Forecast struct {
+	Value  float64 // Median of the values imputed by the trees
+	Spread float64 // Standard deviation of the values imputed by the trees
+	Lower  float64 // Value less one standard deviation, for an expected band
+	Upper  float64 // Value plus one standard deviation, for an expected band
+}
+
+// Deviation returns the distance of an observed value from the forecast,
+// in units of the spread across the trees
+func (fc Forecast) Deviation(value float64) float64 {
+	if fc.Spread == 0 {
+		if value == fc.Value {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return math.Abs(value-fc.Value) / fc.Spread
+}
+
+// Forecast predicts the next values of a single-valued stream, up to the given horizon
+//
+// At each step the shingle is moved on by one value, with the new value missing.
+// Each tree imputes the missing value, and the median across the trees becomes the
+// forecast for that step and the last value of the shingle for the next step.
+// The forest and its shingle are not changed.
+func (f *Forest[K]) Forecast(horizon int) ([]Forecast, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.ShingleSize == 0 || horizon <= 0 {
+		return nil, fmt.Errorf("%w: shingle size (%d), horizon (%d)", ErrInvalidParameter, f.ShingleSize, horizon)
+	}
+	if f.shingleDims > 1 {
+		return nil, fmt.Errorf("%w: forecasting needs a single-valued stream", ErrInvalidParameter)
+	}
+	if len(f.Shingle) < f.ShingleSize {
+		return nil, fmt.Errorf("%w: shingle not yet filled", ErrInsufficientData)
+	}
+
+	shingle := f.Shingle
+	forecasts := make([]Forecast, horizon)
+	values := make([]float64, len(f.Trees))
+	for step := range forecasts {
+		var block int
+		shingle, block = shiftShingle(shingle, f.shingleCount+step, []float64{math.NaN()}, f.ShingleSize, f.shingleMode)
+
+		// Impute the next value in each tree in parallel
+		found := make([]bool, len(f.Trees))
+		err := f.forEachTree(len(f.Trees), func(treeIndex int) error {
+			if f.Trees[treeIndex].Root == nil {
+				return nil
+			}
+			imputed, err := f.Trees[treeIndex].Impute(shingle)
+			if err != nil {
+				return err
+			}
+			values[treeIndex] = imputed[block]
+			found[treeIndex] = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var treeValues []float64
+		for treeIndex, value := range values {
+			if found[treeIndex] {
+				treeValues = append(treeValues, value)
+			}
+		}
+		if len(treeValues) == 0 {
+			return nil, fmt.Errorf("%w: no points to forecast from", ErrInsufficientData)
+		}
+
+		// Spread of the values imputed by the trees
+		var mean, variance float64
+		for _, value := range treeValues {
+			mean += value / float64(len(treeValues))
+		}
+		for _, value := range treeValues {
+			variance += (value - mean) * (value - mean) / float64(len(treeValues))
+		}
+
+		forecast := Forecast{Value: median(treeValues), Spread: math.Sqrt(variance)}
+		forecast.Lower = forecast.Value - forecast.Spread
+		forecast.Upper = forecast.Value + forecast.Spread
+		forecasts[step] = forecast
+
+		// Roll forward with the forecast value
+		shingle[block] = forecast.Value
+	}
+	return forecasts, nil
+}
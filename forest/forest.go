@@ -0,0 +1,683 @@
+package forest
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/andysgithub/go-rrcf/array"
+	"github.com/andysgithub/go-rrcf/random"
+	"github.com/andysgithub/go-rrcf/rrcf"
+)
+
+var (
+	// ErrNoSuchTree is returned for a tree index outside the forest
+	ErrNoSuchTree = errors.New("No such tree index")
+	// ErrInvalidParameter is returned when a forest is created with invalid sizes
+	ErrInvalidParameter = errors.New("Invalid forest parameter")
+	// ErrInsufficientData is returned when there is too little data to build the trees
+	ErrInsufficientData = errors.New("Insufficient data to build forest")
+	// ErrTreePanic is returned when the work for a tree panics
+	ErrTreePanic = errors.New("Tree operation failed")
+)
+
+// Forest records the trees and streaming state of a robust random cut forest, holding
+// points under index labels of any comparable type, such as sample indices, event IDs,
+// timestamps or UUIDs
+//
+// The methods of a forest are safe for concurrent use. Scoring may run concurrently
+// with other scoring, while updates to the trees are serialized. Fields should not be
+// changed directly once the forest is in use.
+type Forest[K comparable] struct {
+	Trees       []rrcf.Tree[K]      // Trees in the forest
+	NumTrees    int                 // Number of trees in the forest
+	TreeSize    int                 // Number of leaves retained in each tree when streaming
+	DataPoints  int                 // Number of points inserted into the trees
+	ShingleSize int                 // Number of points in each shingle
+	Shingle     []float64           // Most recent points of the stream, concatenated into a shingle
+	Rng         *random.RandomState // RandomState used to seed each tree
+	Workers     int                 // Maximum goroutines for per-tree work, or 0 for GOMAXPROCS
+	Samplers    []Sampler[K]        // Sampler deciding the points kept in each tree when streaming
+
+	sampling      SamplingPolicy[K] // Policy creating the Sampler for each tree
+	thresholder   *Thresholder      // Thresholder grading the results of updates, if set
+	episodes      *EpisodeTracker   // EpisodeTracker grouping the results of updates, if set
+	normalization Normalization     // How scores are normalized
+	scoreHistory  *scoreHistory     // Recent raw scores, for normalizing streaming scores
+	scorer        Scorer[K]         // Scorer for the leaves of the trees, or CoDispScorer if not set
+	aggregation   AggregationConfig // How the scores of the trees are combined
+
+	shingleMode  ShingleMode // How points are combined into shingles
+	shingleCount int         // Number of points added to the shingle
+	shingleDims  int         // Dimension of the points in the shingle
+
+	window   *TimeWindow     // Window of event times held by the trees, if streaming by time
+	history  []timedValue    // Recent values of a single-valued stream in event time order
+	timeline timeline[K]     // Event times of the points held by the trees, with stale entries
+	timed    map[K]time.Time // Current event time of each point held by the trees
+	untimed  []K             // Points held without event times, as after WarmStart
+	latest   time.Time       // Latest event time received
+	updates  int             // Number of points assessed, for the episodes of non-integer labels
+	mu       sync.RWMutex    // Guards the trees and streaming state
+}
+
+// NewForest creates a forest from the given source data, with integer sample indices
+// If data is nil, a forest of empty trees is created for streaming
+func NewForest(numTrees int, treeSize int, data [][]float64, shingleSize int, randomState interface{}) (*Forest[int], error) {
+	forest, err := newForest[int](numTrees, treeSize, shingleSize, randomState)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		forest.NewEmptyTrees()
+	} else if err := forest.Build(data); err != nil {
+		return nil, err
+	}
+	return forest, nil
+}
+
+// NewLabeledForest creates a forest of empty trees for streaming points under labels
+// of any comparable type, such as event IDs, timestamps or UUIDs
+func NewLabeledForest[K comparable](numTrees int, treeSize int, shingleSize int, randomState interface{}) (*Forest[K], error) {
+	forest, err := newForest[K](numTrees, treeSize, shingleSize, randomState)
+	if err != nil {
+		return nil, err
+	}
+	forest.NewEmptyTrees()
+	return forest, nil
+}
+
+func newForest[K comparable](numTrees int, treeSize int, shingleSize int, randomState interface{}) (*Forest[K], error) {
+	if numTrees <= 0 || treeSize <= 0 || shingleSize < 0 {
+		return nil, fmt.Errorf("%w: trees (%d), tree size (%d), shingle size (%d)", ErrInvalidParameter, numTrees, treeSize, shingleSize)
+	}
+	return &Forest[K]{
+		NumTrees:    numTrees,
+		TreeSize:    treeSize,
+		ShingleSize: shingleSize,
+		Rng:         random.NewRandomStateFrom(randomState),
+	}, nil
+}
+
+// NewEmptyTrees appends empty trees to the forest until it holds NumTrees trees
+func (f *Forest[K]) NewEmptyTrees() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.Trees) < f.NumTrees {
+		f.newTree(nil, nil, 0)
+	}
+}
+
+// Build constructs the trees of the forest in batch from random subsets of the source data
+// The data must hold at least twice TreeSize points of equal dimension, and the points
+// are labelled by their rows in the data, so the forest must have integer labels
+func (f *Forest[K]) Build(data [][]float64) error {
+	if _, ok := any(0).(K); !ok {
+		return fmt.Errorf("%w: building from data needs integer labels", ErrInvalidParameter)
+	}
+	dataPoints := len(data)
+	if dataPoints < 2*f.TreeSize {
+		return fmt.Errorf("%w: %d points for tree size %d", ErrInsufficientData, dataPoints, f.TreeSize)
+	}
+	for _, point := range data {
+		if len(point) == 0 || len(point) != len(data[0]) {
+			return fmt.Errorf("%w: point (%d), data (%d)", rrcf.ErrDimensionMismatch, len(point), len(data[0]))
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sampleSizeRange := []int{int(dataPoints / f.TreeSize), f.TreeSize}
+
+	// Select the points and seed for each tree in turn, so the forest is reproducible
+	var samples [][]int
+	var seeds []int64
+	for len(f.Trees)+len(samples) < f.NumTrees {
+		// Select random subsets of points uniformly
+		rows := sampleSizeRange[0]
+		cols := sampleSizeRange[1]
+		ixs := f.Rng.Array(dataPoints, rows, cols)
+		for _, ix := range ixs[0 : rows-1] {
+			samples = append(samples, ix)
+			seeds = append(seeds, f.Rng.Int63())
+		}
+	}
+
+	// Construct the trees in parallel
+	trees := make([]rrcf.Tree[K], len(samples))
+	err := f.forEachTree(len(samples), func(i int) error {
+		// Produce a new array as sampled rows from source data
+		sampledX := array.Sample(data, samples[i])
+		var err error
+		trees[i], err = rrcf.NewTree(sampledX, any(samples[i]).([]K), 9, seeds[i])
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	f.Trees = append(f.Trees, trees...)
+	f.DataPoints += dataPoints
+	return nil
+}
+
+// NewTree creates a new tree and appends it to the forest
+// Each tree is given its own RandomState, seeded from the forest
+// Returns an error if the index labels do not match the rows of X
+func (f *Forest[K]) NewTree(X [][]float64, indexLabels []K, precision int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.newTree(X, indexLabels, precision)
+}
+
+func (f *Forest[K]) newTree(X [][]float64, indexLabels []K, precision int) error {
+	tree, err := rrcf.NewTree(X, indexLabels, precision, f.Rng.Int63())
+	if err != nil {
+		return err
+	}
+	f.Trees = append(f.Trees, tree)
+	return nil
+}
+
+// forEachTree calls fn for tree indices 0 to numTrees-1, spreading the calls
+// across at most Workers goroutines
+// A call that panics is recovered and reported as ErrTreePanic, so that one bad tree
+// cannot crash the process. Returns the error for the lowest tree index, if any
+func (f *Forest[K]) forEachTree(numTrees int, fn func(treeIndex int) error) error {
+	workers := f.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > numTrees {
+		workers = numTrees
+	}
+
+	errs := make([]error, numTrees)
+	if workers <= 1 {
+		for treeIndex := 0; treeIndex < numTrees; treeIndex++ {
+			errs[treeIndex] = callTree(fn, treeIndex)
+		}
+	} else {
+		treeIndices := make(chan int)
+		var wg sync.WaitGroup
+		for worker := 0; worker < workers; worker++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for treeIndex := range treeIndices {
+					errs[treeIndex] = callTree(fn, treeIndex)
+				}
+			}()
+		}
+		for treeIndex := 0; treeIndex < numTrees; treeIndex++ {
+			treeIndices <- treeIndex
+		}
+		close(treeIndices)
+		wg.Wait()
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// callTree calls fn for a tree index, returning any panic as an error
+func callTree(fn func(treeIndex int) error, treeIndex int) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: tree %d: %v", ErrTreePanic, treeIndex, r)
+		}
+	}()
+	return fn(treeIndex)
+}
+
+// Update maintains a shingle internally by retaining previous data points,
+// then inserts the point into each tree and returns its average score
+// Returns 0 until enough values have been received to fill the shingle
+func (f *Forest[K]) Update(sampleIndex K, point []float64) (float64, error) {
+	result, err := f.UpdateResult(sampleIndex, point)
+	return result.Score, err
+}
+
+// UpdateResult updates the forest as for Update, and returns the average score
+// together with the contribution of each dimension of the inserted point
+// For a shingled stream, the contributions are also given by lag, so that
+// sampleIndex-PeakLag() locates the point in the shingle that made it anomalous
+// Missing values, set to NaN, are imputed from the trees before the point is inserted
+// The result is zero until enough values have been received to fill the shingle
+// If normalization, a threshold or episode tracking is set, the score is normalized,
+// graded and tracked in turn
+func (f *Forest[K]) UpdateResult(sampleIndex K, point []float64) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result, err := f.updateResult(sampleIndex, point)
+	if err == nil {
+		f.assess(sampleIndex, &result)
+	}
+	return result, err
+}
+
+func (f *Forest[K]) updateResult(sampleIndex K, point []float64) (Result, error) {
+	if len(point) == 0 {
+		return Result{}, fmt.Errorf("%w: point (0)", rrcf.ErrDimensionMismatch)
+	}
+	if f.shingled(point) {
+		return f.updateShingle(point, func(shingle []float64) (Result, error) {
+			return f.updatePoint(sampleIndex, shingle)
+		})
+	}
+
+	point, err := f.impute(point)
+	if err != nil {
+		return Result{}, err
+	}
+	return f.updatePoint(sampleIndex, point)
+}
+
+// updateShingle adds a point to the shingle and passes the filled shingle to insert,
+// keeping the new shingle only if the insertion succeeds
+func (f *Forest[K]) updateShingle(point []float64, insert func(shingle []float64) (Result, error)) (Result, error) {
+	shingle, block, err := f.nextShingle(point)
+	if err != nil {
+		return Result{}, err
+	}
+	if len(shingle) < f.ShingleSize*len(point) {
+		if hasMissing(point) {
+			return Result{}, fmt.Errorf("%w: cannot impute before shingle is filled", ErrInsufficientData)
+		}
+		f.setShingle(shingle, len(point))
+		return Result{Attribution: make([]float64, f.ShingleSize*len(point)), Lags: make([]float64, f.ShingleSize)}, nil
+	}
+	if shingle, err = f.impute(shingle); err != nil {
+		return Result{}, err
+	}
+	result, err := insert(shingle)
+	if err == nil {
+		f.setShingle(shingle, len(point))
+		result.Lags = lagsFromShingle(result.Attribution, f.ShingleSize, block)
+	}
+	return result, err
+}
+
+// UpdatePoint inserts a new point into each tree and returns the average score
+// The point is checked against every tree before any tree is changed
+func (f *Forest[K]) UpdatePoint(sampleIndex K, point []float64) (float64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result, err := f.updatePoint(sampleIndex, point)
+	return result.Score, err
+}
+
+func (f *Forest[K]) updatePoint(sampleIndex K, point []float64) (Result, error) {
+	if err := f.checkPoint(sampleIndex, point); err != nil {
+		return Result{}, err
+	}
+	f.prepareSamplers()
+	scores := make([]float64, f.NumTrees)
+	attributions := make([][]float64, f.NumTrees)
+	kept := make([]bool, f.NumTrees)
+	forgotten := make([][]K, f.NumTrees)
+
+	// For each tree in the forest
+	err := f.forEachTree(f.NumTrees, func(treeIndex int) error {
+		tree := &f.Trees[treeIndex]
+		sampler := f.Samplers[treeIndex]
+		keep, forget := sampler.Sample(tree, sampleIndex, f.treeSize())
+		kept[treeIndex] = keep
+		if keep {
+			// Drop the points the sampler replaces, if they are still in the tree
+			for i, index := range forget {
+				_, err := tree.ForgetPoint(index)
+				if err == nil {
+					forgotten[treeIndex] = append(forgotten[treeIndex], index)
+				} else if !errors.Is(err, rrcf.ErrNoSuchLeaf) {
+					f.forgetSampled(treeIndex, forget[:i])
+					return err
+				}
+			}
+		}
+		// Insert the new point into the tree, then commit the sampler's decision
+		if _, err := tree.InsertPoint(point, sampleIndex, 0); err != nil {
+			if keep {
+				f.forgetSampled(treeIndex, forget)
+			}
+			return err
+		}
+		sampler.Commit(sampleIndex, keep, forget)
+
+		// Score the new point and find the share of each dimension
+		var err error
+		scores[treeIndex], attributions[treeIndex], err = f.scoreResult(tree, sampleIndex)
+		if !keep {
+			// The point is scored by the tree but not kept
+			if _, forgetErr := tree.ForgetPoint(sampleIndex); err == nil {
+				err = forgetErr
+			}
+		}
+		return err
+	})
+	for _, indices := range forgotten {
+		f.dropTimes(indices)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	for _, keep := range kept {
+		if keep {
+			f.DataPoints++
+		}
+	}
+
+	// Take the average over all trees, then combine the scores as set
+	result := Result{Attribution: make([]float64, len(point)), Point: point}
+	for treeIndex, score := range scores {
+		result.addResult(score, attributions[treeIndex], float64(f.NumTrees))
+	}
+	f.aggregate(&result, scores)
+	return result, nil
+}
+
+// checkPoint returns an error if a point cannot be inserted into every tree
+func (f *Forest[K]) checkPoint(sampleIndex K, point []float64) error {
+	if len(point) == 0 {
+		return fmt.Errorf("%w: point (0)", rrcf.ErrDimensionMismatch)
+	}
+	if len(f.Trees) < f.NumTrees {
+		return fmt.Errorf("%w: %d of %d trees in forest", ErrNoSuchTree, len(f.Trees), f.NumTrees)
+	}
+	for _, tree := range f.Trees[:f.NumTrees] {
+		if tree.Root != nil && len(point) != tree.Ndim {
+			return fmt.Errorf("%w: point (%d), tree (%d)", rrcf.ErrDimensionMismatch, len(point), tree.Ndim)
+		}
+		if _, exists := tree.Leaves[sampleIndex]; exists {
+			return fmt.Errorf("%w: %v", rrcf.ErrDuplicateIndex, sampleIndex)
+		}
+	}
+	return nil
+}
+
+// Score calculates the average score at each leaf across all trees, or combines the
+// scores as set by SetAggregation
+// If normalization is set, the scores are normalized against each other
+func (f *Forest[K]) Score() map[K]float64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	// Compute the scores of each tree in parallel
+	treeScores := make([]map[K]float64, len(f.Trees))
+	f.forEachTree(len(f.Trees), func(treeIndex int) error {
+		treeScores[treeIndex] = f.scoreTree(&f.Trees[treeIndex])
+		return nil
+	})
+
+	// Combine the scores at each leaf, taking the trees in order
+	leafScores := collectScores(treeScores)
+	avgScores := make(map[K]float64, len(leafScores))
+	for key, scores := range leafScores {
+		avgScores[key] = f.aggregation.aggregate(scores)
+	}
+	if f.normalization != NormalizeNone {
+		keys := make([]K, 0, len(avgScores))
+		scores := make([]float64, 0, len(avgScores))
+		for key, score := range avgScores {
+			keys = append(keys, key)
+			scores = append(scores, score)
+		}
+		for i, score := range f.normalizeAll(scores) {
+			avgScores[keys[i]] = score
+		}
+	}
+
+	return avgScores
+}
+
+// ScoreResults calculates the average score at each leaf across all trees, or combines
+// the scores as set by SetAggregation, together with the contribution of each dimension
+// of the leaf to its score
+// If normalization is set, the scores are normalized against each other
+func (f *Forest[K]) ScoreResults() map[K]Result {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	// Compute the scores and attributions of each tree in parallel
+	treeResults := make([]map[K]Result, len(f.Trees))
+	f.forEachTree(len(f.Trees), func(treeIndex int) error {
+		tree := &f.Trees[treeIndex]
+		treeResults[treeIndex] = make(map[K]Result, len(tree.Leaves))
+		for key := range tree.Leaves {
+			score, attribution, _ := f.scoreResult(tree, key)
+			treeResults[treeIndex][key] = Result{Score: score, Attribution: attribution}
+		}
+		return nil
+	})
+
+	// Create a map to store the total occurences of each leaf index in the forest
+	leafTotals := make(map[K]float64)
+	for _, results := range treeResults {
+		for key := range results {
+			leafTotals[key]++
+		}
+	}
+
+	// Average the results at each leaf, adding the trees in order, then combine the
+	// scores as set
+	avgResults := make(map[K]Result, len(leafTotals))
+	leafScores := make(map[K][]float64, len(leafTotals))
+	for _, results := range treeResults {
+		for key, result := range results {
+			avgResult := avgResults[key]
+			avgResult.addResult(result.Score, result.Attribution, leafTotals[key])
+			avgResults[key] = avgResult
+			leafScores[key] = append(leafScores[key], result.Score)
+		}
+	}
+	for key, scores := range leafScores {
+		avgResult := avgResults[key]
+		f.aggregate(&avgResult, scores)
+		avgResults[key] = avgResult
+	}
+	if f.normalization != NormalizeNone {
+		keys := make([]K, 0, len(avgResults))
+		scores := make([]float64, 0, len(avgResults))
+		for key, result := range avgResults {
+			keys = append(keys, key)
+			scores = append(scores, result.Score)
+		}
+		for i, score := range f.normalizeAll(scores) {
+			result := avgResults[keys[i]]
+			result.RawScore = result.Score
+			result.scale(score)
+			avgResults[keys[i]] = result
+		}
+	}
+	return avgResults
+}
+
+// scoringPoint returns the point to score for a new value of the stream, added to the
+// current shingle without changing it and with any missing values imputed
+// Returns nil if the shingle would not yet be filled
+func (f *Forest[K]) scoringPoint(point []float64) ([]float64, error) {
+	if len(point) == 0 {
+		return nil, fmt.Errorf("%w: point (0)", rrcf.ErrDimensionMismatch)
+	}
+	if f.shingled(point) {
+		shingle, _, err := f.nextShingle(point)
+		if err != nil {
+			return nil, err
+		}
+		if len(shingle) < f.ShingleSize*len(point) {
+			return nil, nil
+		}
+		point = shingle
+	}
+	if len(f.Trees) < f.NumTrees {
+		return nil, fmt.Errorf("%w: %d of %d trees in forest", ErrNoSuchTree, len(f.Trees), f.NumTrees)
+	}
+	return f.impute(point)
+}
+
+// collectScores gathers the scores of each leaf from the trees, taking the trees in order
+func collectScores[K comparable](treeScores []map[K]float64) map[K][]float64 {
+	leafScores := make(map[K][]float64)
+	for _, scores := range treeScores {
+		for key, score := range scores {
+			leafScores[key] = append(leafScores[key], score)
+		}
+	}
+	return leafScores
+}
+
+// ScorePoint returns the average score a point would receive if it were inserted
+// into each tree, without changing the trees, the shingle or any random state
+// The scores of the trees are combined as set by SetAggregation
+// A point is added to the current shingle, as for Update, and scores 0 if the
+// shingle would not yet be filled
+// Missing values, set to NaN, are imputed from the trees before scoring
+// If normalization is set, the score is normalized against the recent scores of the
+// stream, without being added to them
+func (f *Forest[K]) ScorePoint(point []float64) (float64, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	point, err := f.scoringPoint(point)
+	if point == nil || err != nil {
+		return 0, err
+	}
+	scorer, err := f.pointScorer()
+	if err != nil {
+		return 0, err
+	}
+
+	scores := make([]float64, f.NumTrees)
+	err = f.forEachTree(f.NumTrees, func(treeIndex int) error {
+		var err error
+		scores[treeIndex], err = scorer.ScorePoint(&f.Trees[treeIndex], point)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Take the average over all trees, or combine the scores as set
+	var avgScore float64
+	if f.aggregation.Method == AggregateMean {
+		for _, score := range scores {
+			avgScore += score / float64(f.NumTrees)
+		}
+	} else {
+		avgScore = f.aggregation.aggregate(scores)
+	}
+	return f.normalizedScore(avgScore), nil
+}
+
+// InsertPoint inserts a point into a tree, creating a new leaf
+func (f *Forest[K]) InsertPoint(treeIndex int, point []float64, index K, tolerance float64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.checkTree(treeIndex); err != nil {
+		return err
+	}
+	_, err := f.Trees[treeIndex].InsertPoint(point, index, tolerance)
+	if err == nil {
+		f.DataPoints++
+	}
+	return err
+}
+
+// ForgetPoint deletes a leaf from the specified tree
+func (f *Forest[K]) ForgetPoint(treeIndex int, index K) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.checkTree(treeIndex); err != nil {
+		return err
+	}
+	_, err := f.Trees[treeIndex].ForgetPoint(index)
+	if err == nil {
+		f.forgetSampled(treeIndex, []K{index})
+		f.dropTimes([]K{index})
+	}
+	return err
+}
+
+// forgetSampled tells the Sampler of a tree that points have been removed from the tree
+func (f *Forest[K]) forgetSampled(treeIndex int, indices []K) {
+	if treeIndex >= len(f.Samplers) {
+		return
+	}
+	for _, index := range indices {
+		f.Samplers[treeIndex].Forget(index)
+	}
+}
+
+// Forget deletes the point with the given label from every tree holding it
+// Returns rrcf.ErrNoSuchLeaf if no tree holds the point
+func (f *Forest[K]) Forget(sampleIndex K) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	found := false
+	for treeIndex := range f.Trees {
+		_, err := f.Trees[treeIndex].ForgetPoint(sampleIndex)
+		if err == nil {
+			found = true
+			f.forgetSampled(treeIndex, []K{sampleIndex})
+		} else if !errors.Is(err, rrcf.ErrNoSuchLeaf) {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: %v", rrcf.ErrNoSuchLeaf, sampleIndex)
+	}
+	f.dropTimes([]K{sampleIndex})
+	return nil
+}
+
+// TotalTrees returns the total number of trees in the forest
+func (f *Forest[K]) TotalTrees() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return len(f.Trees)
+}
+
+// TotalLeaves returns the number of leaves in the specified tree
+func (f *Forest[K]) TotalLeaves(treeIndex int) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if err := f.checkTree(treeIndex); err != nil {
+		return 0, err
+	}
+	return len(f.Trees[treeIndex].Leaves), nil
+}
+
+// GetScore returns the score for a leaf in the specified tree, from the forest's Scorer
+func (f *Forest[K]) GetScore(treeIndex int, sampleIndex K) (float64, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if err := f.checkTree(treeIndex); err != nil {
+		return 0, err
+	}
+	return f.scoreLeaf(&f.Trees[treeIndex], sampleIndex)
+}
+
+// checkTree returns ErrNoSuchTree if the tree index is outside the forest
+func (f *Forest[K]) checkTree(treeIndex int) error {
+	if treeIndex < 0 || treeIndex >= len(f.Trees) {
+		return fmt.Errorf("%w: %d", ErrNoSuchTree, treeIndex)
+	}
+	return nil
+}
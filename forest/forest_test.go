@@ -0,0 +1,1375 @@
+package forest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andysgithub/go-rrcf/random"
+	"github.com/andysgithub/go-rrcf/rrcf"
+	"github.com/stretchr/testify/assert"
+)
+
+// sineData generates a single-valued sine wave with an injected anomaly
+func sineData(n int) [][]float64 {
+	data := make([][]float64, n)
+	for i := range data {
+		data[i] = []float64{50 + 10*math.Sin(float64(i)*2*math.Pi/50)}
+	}
+	for i := n - 40; i < n-30; i++ {
+		data[i][0] = 50
+	}
+	return data
+}
+
+func TestNewEmptyForest(t *testing.T) {
+	forest, _ := NewForest(10, 64, nil, 4, 0)
+
+	assert.Equal(t, 10, forest.TotalTrees(), "Wrong number of trees")
+	for treeIndex := 0; treeIndex < forest.TotalTrees(); treeIndex++ {
+		leaves, _ := forest.TotalLeaves(treeIndex)
+		assert.Equal(t, 0, leaves, "Empty tree has leaves")
+	}
+}
+
+func TestBatchForest(t *testing.T) {
+	rnd := random.NewRandomState(0)
+	data := rnd.Normal2D(1000, 3)
+	data[0] = []float64{10, 10, 10}
+
+	forest, _ := NewForest(20, 64, data, 0, 0)
+	assert.GreaterOrEqual(t, forest.TotalTrees(), 20, "Too few trees in forest")
+
+	// Constant data is held in a single leaf of each tree
+	constant := make([][]float64, 64)
+	for i := range constant {
+		constant[i] = []float64{5}
+	}
+	constantForest, err := NewForest(4, 16, constant, 0, 1)
+	assert.NoError(t, err)
+	for treeIndex := 0; treeIndex < constantForest.TotalTrees(); treeIndex++ {
+		assert.Len(t, constantForest.Trees[treeIndex].Leaves, 16, "Constant points not held")
+	}
+	for index, score := range constantForest.Score() {
+		assert.Equal(t, 0.0, score, "Constant point %d scored", index)
+	}
+
+	scores := forest.Score()
+	for index, score := range scores {
+		if index != 0 {
+			assert.Greater(t, scores[0], score, fmt.Sprintf("Outlier scored below point %d", index))
+		}
+	}
+
+	// Scoring every leaf in one pass matches scoring each leaf
+	for _, scorer := range []Scorer[int]{CoDispScorer[int](), IsolationScorer[int]()} {
+		forest.SetScorer(scorer)
+		scores := forest.Score()
+		results := forest.ScoreResults()
+		assert.Len(t, scores, len(results))
+		for index, result := range results {
+			assert.InDelta(t, result.Score, scores[index], 1e-9)
+		}
+	}
+}
+
+func TestStreamingForest(t *testing.T) {
+	forest, _ := NewForest(20, 64, nil, 4, 0)
+	data := sineData(400)
+
+	scores := make(map[int]float64)
+	for sampleIndex, point := range data {
+		scores[sampleIndex], _ = forest.Update(sampleIndex, point)
+	}
+
+	// Scores are zero until the shingle is filled
+	for sampleIndex := 0; sampleIndex < 3; sampleIndex++ {
+		assert.Equal(t, float64(0), scores[sampleIndex], "Score given before shingle filled")
+	}
+	// Trees are limited to the requested size
+	for treeIndex := 0; treeIndex < forest.TotalTrees(); treeIndex++ {
+		leaves, _ := forest.TotalLeaves(treeIndex)
+		assert.LessOrEqual(t, leaves, forest.TreeSize+1, "Tree exceeds permitted size")
+	}
+	// The anomaly onset scores above the regular signal
+	assert.Greater(t, scores[len(data)-40], scores[len(data)-60], "Anomaly not detected")
+}
+
+func TestSeededForest(t *testing.T) {
+	forest1, _ := NewForest(10, 32, nil, 4, 42)
+	forest2, _ := NewForest(10, 32, nil, 4, 42)
+
+	for sampleIndex, point := range sineData(200) {
+		score1, _ := forest1.Update(sampleIndex, point)
+		score2, _ := forest2.Update(sampleIndex, point)
+		assert.Equal(t, score1, score2, "Seeded forests diverged")
+	}
+}
+
+func TestForestErrors(t *testing.T) {
+	_, err := NewForest(0, 64, nil, 4, 0)
+	assert.True(t, errors.Is(err, ErrInvalidParameter), "Empty forest accepted: %v", err)
+	_, err = NewForest(10, 64, [][]float64{{1, 2}, {3, 4}}, 0, 0)
+	assert.True(t, errors.Is(err, ErrInsufficientData), "Too little data accepted: %v", err)
+
+	forest, _ := NewForest(10, 64, nil, 0, 0)
+	_, err = forest.Update(0, []float64{1, 2})
+	assert.NoError(t, err)
+
+	_, err = forest.Update(1, []float64{1, 2, 3})
+	assert.True(t, errors.Is(err, rrcf.ErrDimensionMismatch), "Wrong dimension accepted: %v", err)
+	_, err = forest.Update(0, []float64{3, 4})
+	assert.True(t, errors.Is(err, rrcf.ErrDuplicateIndex), "Duplicate index accepted: %v", err)
+	for treeIndex := 0; treeIndex < forest.TotalTrees(); treeIndex++ {
+		leaves, _ := forest.TotalLeaves(treeIndex)
+		assert.Equal(t, 1, leaves, "Tree changed by rejected point")
+	}
+
+	err = forest.InsertPoint(10, []float64{1, 2}, 1, 0)
+	assert.True(t, errors.Is(err, ErrNoSuchTree), "Missing tree accepted: %v", err)
+	err = forest.ForgetPoint(-1, 0)
+	assert.True(t, errors.Is(err, ErrNoSuchTree), "Missing tree accepted: %v", err)
+	err = forest.ForgetPoint(0, 5)
+	assert.True(t, errors.Is(err, rrcf.ErrNoSuchLeaf), "Missing leaf accepted: %v", err)
+	_, err = forest.GetScore(0, 5)
+	assert.True(t, errors.Is(err, rrcf.ErrNoSuchLeaf), "Missing leaf accepted: %v", err)
+}
+
+func TestParallelForest(t *testing.T) {
+	sequential, _ := NewForest(16, 64, nil, 4, 42)
+	sequential.Workers = 1
+	parallel, _ := NewForest(16, 64, nil, 4, 42)
+	parallel.Workers = 8
+
+	for sampleIndex, point := range sineData(300) {
+		score1, _ := sequential.Update(sampleIndex, point)
+		score2, _ := parallel.Update(sampleIndex, point)
+		assert.Equal(t, score1, score2, "Parallel score differs from sequential score")
+	}
+	assert.Equal(t, sequential.Score(), parallel.Score(), "Parallel batch scores differ")
+
+	rnd := random.NewRandomState(0)
+	data := rnd.Normal2D(500, 3)
+	sequential, _ = NewForest(10, 32, data, 0, 7)
+	sequential.Workers = 1
+	parallel, _ = NewForest(10, 32, data, 0, 7)
+	assert.Equal(t, sequential.Score(), parallel.Score(), "Parallel batch build differs")
+
+	// A panic in the work for a tree is returned as an error, however many workers
+	for _, forest := range []*Forest[int]{sequential, parallel} {
+		err := forest.forEachTree(10, func(treeIndex int) error {
+			if treeIndex == 6 {
+				panic("bad tree")
+			}
+			return nil
+		})
+		assert.True(t, errors.Is(err, ErrTreePanic), "Panic not recovered: %v", err)
+	}
+}
+
+func TestConcurrentStreams(t *testing.T) {
+	shared, _ := NewForest(8, 2000, nil, 4, 1)
+	data := sineData(200)
+
+	var wg sync.WaitGroup
+	for stream := 0; stream < 8; stream++ {
+		wg.Add(1)
+		go func(stream int) {
+			defer wg.Done()
+			// Each stream updates its own forest and writes to the shared forest
+			own, _ := NewForest(4, 32, nil, 4, stream)
+			for sampleIndex, point := range data {
+				_, err := own.Update(sampleIndex, point)
+				assert.NoError(t, err)
+				shared.UpdatePoint(stream*len(data)+sampleIndex, []float64{point[0], float64(stream)})
+				if sampleIndex%50 == 0 {
+					shared.Score()
+				}
+			}
+		}(stream)
+	}
+	wg.Wait()
+
+	for treeIndex := 0; treeIndex < shared.TotalTrees(); treeIndex++ {
+		leaves, _ := shared.TotalLeaves(treeIndex)
+		assert.Equal(t, 8*len(data), leaves, "Lost updates to shared forest")
+	}
+}
+
+func TestScorePoint(t *testing.T) {
+	forest, _ := NewForest(20, 64, nil, 4, 0)
+	for sampleIndex, point := range sineData(200) {
+		forest.Update(sampleIndex, point)
+	}
+	scores := forest.Score()
+	shingle := append([]float64(nil), forest.Shingle...)
+	state := forest.Trees[0].State()
+
+	normal, err := forest.ScorePoint([]float64{50 + 10*math.Sin(float64(200)*2*math.Pi/50)})
+	assert.NoError(t, err)
+	outlier, err := forest.ScorePoint([]float64{100})
+	assert.NoError(t, err)
+	assert.Greater(t, outlier, normal, "Outlier scored below regular point")
+
+	assert.Equal(t, scores, forest.Score(), "Scores changed by what-if scoring")
+	assert.Equal(t, shingle, forest.Shingle, "Shingle changed by what-if scoring")
+	assert.Equal(t, state, forest.Trees[0].State(), "Tree changed by what-if scoring")
+
+	_, err = forest.ScorePoint([]float64{1, 2})
+	assert.True(t, errors.Is(err, rrcf.ErrDimensionMismatch), "Wrong dimension accepted: %v", err)
+}
+
+func TestAttribution(t *testing.T) {
+	rnd := random.NewRandomState(0)
+	data := rnd.Normal2D(400, 3)
+	data[0] = []float64{0, 0, 12}
+
+	forest, _ := NewForest(20, 64, data, 0, 0)
+	scores := forest.Score()
+	results := forest.ScoreResults()
+	for index, result := range results {
+		var total float64
+		for _, value := range result.Attribution {
+			total += value
+		}
+		assert.InDelta(t, scores[index], result.Score, 1e-9, "Result score differs from score")
+		assert.InDelta(t, result.Score, total, 1e-9, "Attribution does not sum to score")
+	}
+	outlier := results[0].Attribution
+	assert.Greater(t, outlier[2], outlier[0]+outlier[1], "Anomalous dimension not attributed")
+
+	streaming, _ := NewForest(10, 64, nil, 0, 0)
+	for sampleIndex, point := range data[1:200] {
+		streaming.Update(sampleIndex, point)
+	}
+	result, err := streaming.UpdateResult(200, []float64{0, 12, 0})
+	assert.NoError(t, err)
+	assert.Len(t, result.Attribution, 3)
+	assert.Greater(t, result.Attribution[1], result.Attribution[0]+result.Attribution[2], "Anomalous dimension not attributed")
+}
+
+func TestLagAttribution(t *testing.T) {
+	forest, _ := NewForest(20, 128, nil, 4, 0)
+	data := sineData(300)
+	// Inject a single spike into the regular signal
+	spike := 250
+	data[spike][0] = 90
+
+	results := make(map[int]Result)
+	for sampleIndex, point := range data {
+		results[sampleIndex], _ = forest.UpdateResult(sampleIndex, point)
+	}
+
+	assert.Len(t, results[0].Lags, 4, "Lags missing before shingle filled")
+	for lag := 0; lag < 4; lag++ {
+		result := results[spike+lag]
+		assert.Equal(t, lag, result.PeakLag(), fmt.Sprintf("Spike not located %d samples after it", lag))
+		assert.Equal(t, result.Attribution[3-lag], result.Lags[lag], "Lags not in reverse shingle order")
+	}
+	assert.Equal(t, -1, Result{}.PeakLag(), "Peak lag found without lags")
+}
+
+func TestImpute(t *testing.T) {
+	forest, _ := NewForest(20, 64, nil, 0, 0)
+	for sampleIndex := 0; sampleIndex < 200; sampleIndex++ {
+		x := float64(sampleIndex%50) / 10
+		forest.Update(sampleIndex, []float64{x, 2 * x})
+	}
+	imputed, err := forest.Impute([]float64{3, math.NaN()})
+	assert.NoError(t, err)
+	assert.InDelta(t, 6, imputed[1], 0.5, "Missing value not imputed")
+
+	// Missing values are imputed before insertion
+	result, err := forest.UpdateResult(200, []float64{math.NaN(), 4})
+	assert.NoError(t, err)
+	assert.InDelta(t, 2, result.Point[0], 0.5, "Missing value not imputed on update")
+	_, err = forest.ScorePoint([]float64{1, math.NaN()})
+	assert.NoError(t, err)
+
+	// A single-valued stream imputes a missing value in the shingle
+	streaming, _ := NewForest(20, 64, nil, 4, 0)
+	_, err = streaming.Update(0, []float64{math.NaN()})
+	assert.True(t, errors.Is(err, ErrInsufficientData), "Imputed before shingle filled: %v", err)
+	data := sineData(200)
+	for sampleIndex, point := range data[:150] {
+		streaming.Update(sampleIndex, point)
+	}
+	result, err = streaming.UpdateResult(150, []float64{math.NaN()})
+	assert.NoError(t, err)
+	assert.InDelta(t, data[150][0], result.Point[3], 3, "Missing stream value not imputed")
+	assert.False(t, math.IsNaN(streaming.Shingle[3]), "Missing value kept in shingle")
+}
+
+func TestForecast(t *testing.T) {
+	forest, _ := NewForest(20, 256, nil, 4, 0)
+	_, err := forest.Forecast(5)
+	assert.True(t, errors.Is(err, ErrInsufficientData), "Forecast before shingle filled: %v", err)
+
+	data := sineData(400)
+	for sampleIndex, point := range data[:300] {
+		forest.Update(sampleIndex, point)
+	}
+	shingle := append([]float64(nil), forest.Shingle...)
+
+	forecasts, err := forest.Forecast(5)
+	assert.NoError(t, err)
+	assert.Len(t, forecasts, 5)
+	for step, forecast := range forecasts {
+		assert.InDelta(t, data[300+step][0], forecast.Value, 2, fmt.Sprintf("Forecast step %d far from signal", step))
+		assert.LessOrEqual(t, forecast.Lower, forecast.Value)
+		assert.GreaterOrEqual(t, forecast.Upper, forecast.Value)
+	}
+	assert.Equal(t, shingle, forest.Shingle, "Shingle changed by forecast")
+	assert.Greater(t, forecasts[0].Deviation(100), forecasts[0].Deviation(data[300][0]), "Outlier not deviating from forecast")
+
+	_, err = forest.Forecast(0)
+	assert.True(t, errors.Is(err, ErrInvalidParameter), "Zero horizon accepted: %v", err)
+	unshingled, _ := NewForest(4, 32, nil, 0, 0)
+	_, err = unshingled.Forecast(1)
+	assert.True(t, errors.Is(err, ErrInvalidParameter), "Forecast without shingle: %v", err)
+}
+
+func TestSampling(t *testing.T) {
+	rnd := random.NewRandomState(0)
+	data := rnd.Normal2D(2000, 3)
+
+	policies := map[string]SamplingPolicy[int]{
+		"fifo":      FIFOSampling[int](),
+		"reservoir": ReservoirSampling[int](),
+		"decayed":   DecayedSampling[int](0.01),
+	}
+	oldest := make(map[string]int)
+	for name, policy := range policies {
+		forest, _ := NewForest(8, 100, nil, 0, 3)
+		assert.NoError(t, forest.SetSampling(policy))
+		for sampleIndex, point := range data {
+			score, err := forest.Update(sampleIndex, point)
+			assert.NoError(t, err)
+			assert.GreaterOrEqual(t, score, float64(0))
+		}
+
+		// FIFO trees hold one point beyond the permitted size, as in the Python rrcf
+		// streaming example, and the reservoirs hold the permitted size
+		treeSize := forest.TreeSize
+		if name == "fifo" {
+			treeSize++
+		}
+		oldest[name] = len(data)
+		memberships := make(map[int]int)
+		for treeIndex := 0; treeIndex < forest.TotalTrees(); treeIndex++ {
+			leaves, _ := forest.TotalLeaves(treeIndex)
+			assert.Equal(t, treeSize, leaves, fmt.Sprintf("%s tree not at permitted size", name))
+			for index := range forest.Trees[treeIndex].Leaves {
+				memberships[index]++
+				if index < oldest[name] {
+					oldest[name] = index
+				}
+			}
+		}
+		if name == "fifo" {
+			// Every tree holds the same window of points
+			assert.Equal(t, treeSize, len(memberships), "FIFO trees hold different points")
+		} else {
+			// Each tree samples independently
+			assert.Greater(t, len(memberships), forest.TreeSize, fmt.Sprintf("%s trees hold the same points", name))
+		}
+	}
+	// The reservoirs remember points from before the last TreeSize points
+	assert.Equal(t, len(data)-101, oldest["fifo"], "FIFO kept old points")
+	assert.Less(t, oldest["reservoir"], len(data)/2, "Uniform reservoir lost old points")
+	assert.Less(t, oldest["decayed"], len(data)-100, "Decayed reservoir lost older points")
+	assert.Greater(t, oldest["decayed"], oldest["reservoir"], "Decayed reservoir not weighted to recent points")
+
+	forest, _ := NewForest(8, 100, nil, 0, 3)
+	err := forest.SetSampling(nil)
+	assert.True(t, errors.Is(err, ErrInvalidParameter), "Missing policy accepted: %v", err)
+}
+
+func TestSamplingRemovals(t *testing.T) {
+	rnd := random.NewRandomState(1)
+	data := rnd.Normal2D(600, 2)
+
+	for _, policy := range []SamplingPolicy[int]{ReservoirSampling[int](), DecayedSampling[int](0.01)} {
+		forest, _ := NewForest(4, 50, nil, 0, 3)
+		assert.NoError(t, forest.SetSampling(policy))
+		for sampleIndex, point := range data[:300] {
+			_, err := forest.Update(sampleIndex, point)
+			assert.NoError(t, err)
+		}
+
+		// Points removed outside the sampler are not evicted again, and the trees
+		// refill to their permitted size
+		for _, index := range forest.Trees[0].InsertionOrder()[:10] {
+			assert.NoError(t, forest.ForgetPoint(0, index))
+		}
+		held := forest.Trees[1].InsertionOrder()[0]
+		assert.NoError(t, forest.Forget(held))
+		assert.NoError(t, forest.InsertPoint(2, []float64{9, 9}, 1000, 0))
+		for sampleIndex, point := range data[300:] {
+			_, err := forest.Update(300+sampleIndex, point)
+			assert.NoError(t, err)
+		}
+		for treeIndex := 0; treeIndex < forest.TotalTrees(); treeIndex++ {
+			leaves, _ := forest.TotalLeaves(treeIndex)
+			assert.Equal(t, forest.TreeSize, leaves, "Tree not refilled after removals")
+		}
+	}
+}
+
+func TestSparseIndices(t *testing.T) {
+	forest, _ := NewForest(8, 50, nil, 4, 0)
+	data := sineData(300)
+	// Sample indices with gaps, a restart and a reversal
+	indices := make([]int, len(data))
+	for i := range indices {
+		switch {
+		case i < 100:
+			indices[i] = 1000 + 7*i
+		case i < 200:
+			indices[i] = i - 100
+		default:
+			indices[i] = -i
+		}
+	}
+
+	for i, point := range data {
+		_, err := forest.Update(indices[i], point)
+		assert.NoError(t, err)
+	}
+	for treeIndex := 0; treeIndex < forest.TotalTrees(); treeIndex++ {
+		tree := forest.Trees[treeIndex]
+		assert.Equal(t, forest.TreeSize+1, len(tree.Leaves), "Tree not at permitted size")
+		// The tree holds the most recent points in insertion order
+		assert.Equal(t, indices[len(data)-forest.TreeSize-1:], tree.InsertionOrder(), "Tree does not hold the latest points")
+	}
+
+	// A forest built in batch can continue streaming
+	rnd := random.NewRandomState(0)
+	batch, _ := NewForest(4, 32, rnd.Normal2D(200, 3), 0, 0)
+	for sampleIndex := 0; sampleIndex < 100; sampleIndex++ {
+		_, err := batch.Update(1000+sampleIndex, rnd.Normal1D(3))
+		assert.NoError(t, err)
+	}
+	for treeIndex := 0; treeIndex < batch.TotalTrees(); treeIndex++ {
+		leaves, _ := batch.TotalLeaves(treeIndex)
+		assert.LessOrEqual(t, leaves, batch.TreeSize+1, "Batch tree exceeds permitted size")
+	}
+}
+
+func TestWarmStart(t *testing.T) {
+	data := sineData(600)
+	history := data[:300]
+
+	streamed, _ := NewForest(20, 64, nil, 4, 0)
+	for sampleIndex, point := range history {
+		streamed.Update(sampleIndex, point)
+	}
+	warm, err := NewForestFromHistory(20, 64, history, 4, 0, 0)
+	assert.NoError(t, err)
+
+	// The warm forest holds the same window and shingle as the streamed forest
+	assert.Equal(t, streamed.Shingle, warm.Shingle, "Shingle not primed")
+	for treeIndex := 0; treeIndex < warm.TotalTrees(); treeIndex++ {
+		assert.Equal(t, streamed.Trees[treeIndex].InsertionOrder(), warm.Trees[treeIndex].InsertionOrder(), "Trees hold different windows")
+	}
+
+	// Streaming continues with eviction of the oldest points
+	scores := make(map[int]float64)
+	for sampleIndex := 300; sampleIndex < len(data); sampleIndex++ {
+		scores[sampleIndex], err = warm.Update(sampleIndex, data[sampleIndex])
+		assert.NoError(t, err)
+	}
+	for treeIndex := 0; treeIndex < warm.TotalTrees(); treeIndex++ {
+		order := warm.Trees[treeIndex].InsertionOrder()
+		assert.Equal(t, len(data)-65, order[0], "Oldest points not evicted")
+	}
+	// Scores are consistent from the first streamed point
+	assert.Greater(t, scores[len(data)-40], 2*scores[300], "Anomaly not detected after warm start")
+
+	// Multi-dimensional history is not shingled
+	rnd := random.NewRandomState(0)
+	warm, err = NewForestFromHistory(4, 32, rnd.Normal2D(100, 2), 4, 1000, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1067, warm.Trees[0].InsertionOrder()[0], "Wrong labels for history")
+	_, err = warm.Update(1100, []float64{0, 0})
+	assert.NoError(t, err)
+
+	_, err = NewForestFromHistory(4, 32, [][]float64{{1, 2}, {3}}, 0, 0, 0)
+	assert.True(t, errors.Is(err, rrcf.ErrDimensionMismatch), "Mixed dimensions accepted: %v", err)
+
+	// Histories without two distinct points are held as if streamed
+	single, err := NewForestFromHistory(4, 32, [][]float64{{1, 2}}, 0, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0}, single.Trees[0].InsertionOrder())
+	_, err = single.Update(1, []float64{3, 4})
+	assert.NoError(t, err)
+	constant := make([][]float64, 50)
+	for i := range constant {
+		constant[i] = []float64{5}
+	}
+	streamed, _ = NewForest(4, 32, nil, 4, 0)
+	for sampleIndex, point := range constant {
+		streamed.Update(sampleIndex, point)
+	}
+	warm, err = NewForestFromHistory(4, 32, constant, 4, 0, 0)
+	assert.NoError(t, err)
+	for treeIndex := 0; treeIndex < warm.TotalTrees(); treeIndex++ {
+		assert.Equal(t, streamed.Trees[treeIndex].InsertionOrder(), warm.Trees[treeIndex].InsertionOrder(), "Constant history not held")
+	}
+	expected, _ := streamed.Update(50, []float64{9})
+	score, err := warm.Update(50, []float64{9})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, score, "Constant history scored differently")
+}
+
+func TestLabeledForest(t *testing.T) {
+	rnd := random.NewRandomState(0)
+	data := rnd.Normal2D(500, 2)
+
+	labeled, err := NewLabeledForest[string](4, 50, 0, 5)
+	assert.NoError(t, err)
+	for i, point := range data {
+		_, err := labeled.Update(fmt.Sprintf("event-%d", i), point)
+		assert.NoError(t, err)
+	}
+	_, err = labeled.Update("event-499", data[0])
+	assert.True(t, errors.Is(err, rrcf.ErrDuplicateIndex), "Duplicate label accepted: %v", err)
+
+	// Scores are keyed by the labels of the points still held
+	scores := labeled.Score()
+	assert.Len(t, scores, 51)
+	for i := len(data) - 51; i < len(data); i++ {
+		label := fmt.Sprintf("event-%d", i)
+		score, err := labeled.GetScore(0, label)
+		assert.NoError(t, err)
+		codisp, _ := labeled.Trees[0].CoDisp(label)
+		assert.Equal(t, codisp, score, "Wrong score for label")
+		assert.Contains(t, scores, label)
+	}
+
+	// A point can be forgotten by its label, and the label used again
+	assert.NoError(t, labeled.Forget("event-499"))
+	assert.True(t, errors.Is(labeled.Forget("event-499"), rrcf.ErrNoSuchLeaf), "Forgot missing label")
+	assert.NotContains(t, labeled.Score(), "event-499")
+	leaves, _ := labeled.TotalLeaves(0)
+	assert.Equal(t, 50, leaves)
+	_, err = labeled.Update("event-499", data[0])
+	assert.NoError(t, err)
+	result := labeled.ScoreResults()["event-499"]
+	assert.InDelta(t, result.Score, labeled.Score()["event-499"], 1e-9)
+
+	// Episodes index the points by their position in the stream
+	assert.NoError(t, labeled.SetEpisodes(EpisodeConfig{Enter: 0, Exit: 0}))
+	result, err = labeled.UpdateResult("event-500", data[1])
+	assert.NoError(t, err)
+	assert.Equal(t, 501, result.Events[0].Index, "Wrong position of labelled point")
+
+	// Forests with labels that are not integers cannot be built in batch
+	err = labeled.Build(data)
+	assert.True(t, errors.Is(err, ErrInvalidParameter), "Built with string labels: %v", err)
+}
+
+// constantScorer is a custom Scorer scoring every leaf alike
+type constantScorer struct{}
+
+func (constantScorer) Score(tree *rrcf.Tree[int], sampleIndex int) (float64, error) {
+	return 1, nil
+}
+
+func TestForestState(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := sineData(600)
+
+	// A restored forest continues streaming exactly as the original
+	original, _ := NewForest(10, 64, nil, 4, 3)
+	original.SetSampling(DecayedSampling[int](0.01))
+	original.SetNormalization(NormalizeCalibrated, 100)
+	original.SetAggregation(AggregationConfig{Method: AggregateMedian, TreeScores: true})
+	original.SetThreshold(ThresholdConfig{Rule: PercentileRule, Percentile: 99, WarmUp: 100, Window: 100})
+	original.SetEpisodes(EpisodeConfig{Enter: 0.9, Exit: 0.8, MinDuration: 2})
+	original.SetTimeWindow(TimeWindow{Duration: 50 * time.Second, Lateness: 3 * time.Second, Interval: time.Second})
+	for i, point := range data[:300] {
+		_, err := original.UpdateAt(i, start.Add(time.Duration(i)*time.Second), point)
+		assert.NoError(t, err)
+	}
+	forestJSON, err := json.Marshal(original)
+	assert.NoError(t, err)
+	var state ForestState[int]
+	assert.NoError(t, json.Unmarshal(forestJSON, &state))
+	assert.Len(t, state.Samplers, 10)
+	assert.Len(t, state.Times, 51, "Event times not recorded")
+	assert.NotNil(t, state.Thresholder)
+	restored, err := NewForestFromState(state)
+	assert.NoError(t, err)
+	var buffer bytes.Buffer
+	assert.NoError(t, WriteForest(&buffer, original))
+	assert.Less(t, buffer.Len(), len(forestJSON), "Binary snapshot larger than json")
+	decoded, err := ReadForest(&buffer)
+	assert.NoError(t, err)
+	for i := 300; i < len(data); i++ {
+		eventTime := start.Add(time.Duration(i) * time.Second)
+		expected, err := original.UpdateResultAt(i, eventTime, data[i])
+		assert.NoError(t, err)
+		result, err := restored.UpdateResultAt(i, eventTime, data[i])
+		assert.NoError(t, err)
+		assert.Equal(t, expected, result, "Restored forest diverged at %d", i)
+		result, err = decoded.UpdateResultAt(i, eventTime, data[i])
+		assert.NoError(t, err)
+		assert.Equal(t, expected, result, "Decoded forest diverged at %d", i)
+	}
+	forestJSON, _ = json.Marshal(original)
+	for _, forest := range []*Forest[int]{restored, decoded} {
+		restoredJSON, _ := json.Marshal(forest)
+		assert.JSONEq(t, string(forestJSON), string(restoredJSON), "Restored forest state differs")
+	}
+
+	// Labels, multivariate shingles and reservoir samplers are restored, from a file
+	rnd := random.NewRandomState(0)
+	points := rnd.Normal2D(400, 2)
+	labeled, _ := NewLabeledForest[string](8, 32, 3, 5)
+	labeled.SetSampling(ReservoirSampling[string]())
+	labeled.SetShingling(ShingleRotating)
+	labeled.SetScorer(MinMassCoDispScorer[string](4))
+	for i, point := range points[:200] {
+		_, err := labeled.Update(fmt.Sprintf("event-%d", i), point)
+		assert.NoError(t, err)
+	}
+	filename := t.TempDir() + "/forest.json"
+	assert.NoError(t, SaveForest(labeled, filename))
+	loaded, err := LoadForest[string](filename)
+	assert.NoError(t, err)
+	assert.Equal(t, labeled.ShinglePoints(), loaded.ShinglePoints())
+	for i, point := range points[200:] {
+		label := fmt.Sprintf("event-%d", 200+i)
+		expected, _ := labeled.UpdateResult(label, point)
+		result, err := loaded.UpdateResult(label, point)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, result, "Loaded forest diverged at %s", label)
+	}
+	assert.Equal(t, labeled.Score(), loaded.Score())
+
+	// Custom scorers and incompatible states are rejected
+	original.SetScorer(constantScorer{})
+	_, err = original.State()
+	assert.True(t, errors.Is(err, ErrInvalidParameter), "Saved a custom scorer: %v", err)
+	state.Version = ForestStateVersion + 1
+	_, err = NewForestFromState(state)
+	assert.Error(t, err, "Unknown version accepted")
+	state.Version = ForestStateVersion
+	state.Sampling = "lottery"
+	_, err = NewForestFromState(state)
+	assert.True(t, errors.Is(err, ErrInvalidParameter), "Unknown sampling accepted: %v", err)
+}
+
+func TestForestBinaryErrors(t *testing.T) {
+	forest, _ := NewForest(4, 32, nil, 3, 0)
+	forest.SetSampling(ReservoirSampling[int]())
+	forest.SetThreshold(ThresholdConfig{Rule: ZScoreRule, ZScore: 3})
+	for i, point := range sineData(100) {
+		forest.Update(i, point)
+	}
+	filename := t.TempDir() + "/forest.rrcs"
+	assert.NoError(t, SaveForestBinary(forest, filename))
+	loaded, err := LoadForestBinary(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, forest.Score(), loaded.Score())
+
+	var buffer bytes.Buffer
+	WriteForest(&buffer, forest)
+	snapshot := buffer.Bytes()
+
+	// Truncated snapshots, in the streaming state and in the trees
+	for _, length := range []int{len(snapshot) - 1, len(snapshot) / 2, 20, 6} {
+		_, err := ReadForest(bytes.NewReader(snapshot[:length]))
+		assert.True(t, errors.Is(err, io.ErrUnexpectedEOF), "Truncation at %d not detected: %v", length, err)
+	}
+
+	// Corrupted streaming state
+	corrupted := append([]byte{}, snapshot...)
+	corrupted[10] ^= 0xff
+	_, err = ReadForest(bytes.NewReader(corrupted))
+	assert.True(t, errors.Is(err, rrcf.ErrChecksum), "Corruption not detected: %v", err)
+
+	// Incompatible data
+	var trees bytes.Buffer
+	rrcf.WriteForest(&trees, []rrcf.RCTree{forest.Trees[0]})
+	_, err = ReadForest(&trees)
+	assert.True(t, errors.Is(err, rrcf.ErrNotSnapshot), "Tree snapshot accepted as forest: %v", err)
+	newer := append([]byte{}, snapshot...)
+	newer[len(forestMagic)] = ForestBinaryVersion + 1
+	_, err = ReadForest(bytes.NewReader(newer))
+	assert.True(t, errors.Is(err, rrcf.ErrSnapshotVersion), "Unknown version accepted: %v", err)
+}
+
+func TestTimeWindow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+
+	forest, _ := NewForest(4, 50, nil, 0, 3)
+	_, err := forest.UpdateAt(0, start, []float64{0, 0})
+	assert.True(t, errors.Is(err, ErrInvalidParameter), "Updated without a time window: %v", err)
+	assert.Error(t, forest.SetTimeWindow(TimeWindow{}), "Accepted unbounded window")
+	assert.Error(t, forest.SetTimeWindow(TimeWindow{Duration: time.Minute, Gaps: GapFill}), "Accepted fill without interval")
+
+	// A burst is limited by count, and older points by time
+	assert.NoError(t, forest.SetTimeWindow(TimeWindow{Duration: 10 * time.Second, MaxPoints: 80, Lateness: 2 * time.Second}))
+	rnd := random.NewRandomState(0)
+	data := rnd.Normal2D(300, 2)
+	for sampleIndex, point := range data[:200] {
+		_, err := forest.UpdateAt(sampleIndex, at(0), point)
+		assert.NoError(t, err)
+	}
+	leaves, _ := forest.TotalLeaves(0)
+	assert.Equal(t, 81, leaves, "Burst not limited by count")
+	for i, point := range data[200:] {
+		_, err := forest.UpdateAt(200+i, at(1+i/10), point)
+		assert.NoError(t, err)
+	}
+	order := forest.Trees[0].InsertionOrder()
+	assert.Equal(t, 219, order[0], "Most recent points not kept")
+	assert.Equal(t, 299, order[len(order)-1])
+
+	// A rejected point evicts nothing
+	_, err = forest.UpdateAt(299, at(30), data[0])
+	assert.True(t, errors.Is(err, rrcf.ErrDuplicateIndex), "Accepted duplicate index: %v", err)
+	_, err = forest.UpdateAt(300, at(30), []float64{0, 0, 0})
+	assert.True(t, errors.Is(err, rrcf.ErrDimensionMismatch), "Accepted wrong dimension: %v", err)
+	assert.Equal(t, order, forest.Trees[0].InsertionOrder(), "Points evicted for a rejected point")
+	_, err = forest.UpdateAt(300, at(30), data[0])
+	assert.NoError(t, err)
+	for _, tree := range forest.Trees {
+		assert.Len(t, tree.Leaves, 1, "Points outside the time window held")
+	}
+
+	// Events up to the lateness behind the latest are accepted
+	_, err = forest.UpdateAt(301, at(28), []float64{0, 0})
+	assert.NoError(t, err)
+	_, err = forest.UpdateAt(302, at(27), []float64{0, 0})
+	assert.True(t, errors.Is(err, ErrLateEvent), "Accepted event beyond lateness: %v", err)
+	_, ok := forest.Trees[0].Leaves[302]
+	assert.False(t, ok, "Late event inserted")
+
+	// A label evicted by count and reused is timed from its new event
+	forest, _ = NewForest(4, 50, nil, 0, 3)
+	forest.SetTimeWindow(TimeWindow{Duration: 10 * time.Second, MaxPoints: 4})
+	for sampleIndex := 0; sampleIndex < 6; sampleIndex++ {
+		forest.UpdateAt(sampleIndex, at(sampleIndex), data[sampleIndex])
+	}
+	forest.UpdateAt(0, at(6), data[6])
+	_, err = forest.UpdateAt(7, start.Add(10500*time.Millisecond), data[7])
+	assert.NoError(t, err)
+	for _, tree := range forest.Trees {
+		_, ok := tree.Leaves[0]
+		assert.True(t, ok, "Reused label evicted by its earlier event")
+	}
+
+	// Warm-started points expire with the window
+	history := data[:20]
+	times := make([]time.Time, len(history))
+	for i := range times {
+		times[i] = at(i)
+	}
+	labels := make([]int, len(history))
+	for i := range labels {
+		labels[i] = i
+	}
+	forest, _ = NewForest(4, 50, nil, 0, 3)
+	forest.SetTimeWindow(TimeWindow{Duration: 10 * time.Second})
+	assert.Error(t, forest.WarmStartAt(history, labels, times[1:]), "Accepted times of wrong length")
+	assert.NoError(t, forest.WarmStartAt(history, labels, times))
+	assert.Equal(t, 9, forest.Trees[0].InsertionOrder()[0], "Points outside the time window warm-started")
+	forest.UpdateAt(20, at(25), data[20])
+	assert.Equal(t, []int{15, 16, 17, 18, 19, 20}, forest.Trees[0].InsertionOrder(), "Warm-started points not expired")
+
+	// Untimed points take times spaced before the first event
+	forest, _ = NewForest(4, 50, nil, 0, 3)
+	forest.SetTimeWindow(TimeWindow{Duration: 10 * time.Second, Interval: time.Second})
+	assert.NoError(t, forest.WarmStart(history, labels))
+	forest.UpdateAt(20, at(30), data[20])
+	assert.Equal(t, []int{10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}, forest.Trees[0].InsertionOrder(), "Warm-started points not expired")
+}
+
+func TestTimeWindowShingle(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+	data := sineData(200)
+
+	for _, gaps := range []GapPolicy{GapRestart, GapFill} {
+		forest, _ := NewForest(10, 100, nil, 4, 5)
+		window := TimeWindow{Duration: time.Hour, Lateness: 3 * time.Second, MaxGap: 2 * time.Second, Interval: time.Second, Gaps: gaps}
+		assert.NoError(t, forest.SetTimeWindow(window))
+		for i, point := range data[:150] {
+			_, err := forest.UpdateAt(i, at(i), point)
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, []float64{data[146][0], data[147][0], data[148][0], data[149][0]}, forest.Shingle)
+
+		// The values either side of a gap are never joined directly
+		result, err := forest.UpdateResultAt(150, at(152), data[152])
+		assert.NoError(t, err)
+		if gaps == GapRestart {
+			assert.Equal(t, 0.0, result.Score, "Shingle not restarted after gap")
+			assert.Equal(t, []float64{data[152][0]}, forest.Shingle)
+		} else {
+			assert.Len(t, forest.Shingle, 4)
+			assert.Equal(t, data[149][0], forest.Shingle[0])
+			assert.InDelta(t, data[150][0], forest.Shingle[1], 2, "Gap not filled from the forest")
+			assert.InDelta(t, data[151][0], forest.Shingle[2], 2, "Gap not filled from the forest")
+			assert.Equal(t, data[152][0], forest.Shingle[3])
+			assert.Greater(t, result.Score, 0.0)
+		}
+	}
+
+	// A late value is scored with the values before it, and joins later shingles
+	forest, _ := NewForest(10, 100, nil, 3, 5)
+	forest.SetTimeWindow(TimeWindow{Duration: time.Hour, Lateness: 3 * time.Second, Interval: time.Second})
+	for i := 0; i < 100; i++ {
+		if i != 97 {
+			forest.UpdateAt(i, at(i), data[i])
+		}
+	}
+	assert.Equal(t, []float64{data[96][0], data[98][0], data[99][0]}, forest.Shingle)
+	result, err := forest.UpdateResultAt(97, at(97), data[97])
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{data[95][0], data[96][0], data[97][0]}, result.Point, "Late value not shingled in time order")
+	assert.Equal(t, []float64{data[97][0], data[98][0], data[99][0]}, forest.Shingle)
+}
+
+func TestMultivariateShingle(t *testing.T) {
+	stream := make([][]float64, 400)
+	for i := range stream {
+		angle := float64(i) * 2 * math.Pi / 40
+		stream[i] = []float64{math.Sin(angle), math.Cos(angle)}
+	}
+	stream[350] = []float64{3, -3}
+
+	for _, mode := range []ShingleMode{ShingleSliding, ShingleRotating} {
+		forest, _ := NewForest(20, 128, nil, 3, 2)
+		assert.Error(t, forest.SetShingling(ShingleMode(-1)), "Accepted unknown mode")
+		assert.NoError(t, forest.SetShingling(mode))
+
+		for i, point := range stream[:3] {
+			result, err := forest.UpdateResult(i, point)
+			assert.NoError(t, err)
+			assert.Len(t, result.Attribution, 6)
+			if i < 2 {
+				assert.Equal(t, 0.0, result.Score, "Scored before shingle filled")
+			}
+		}
+		assert.Equal(t, 6, forest.Trees[0].Ndim, "Points not concatenated")
+		assert.Equal(t, [][]float64{stream[0], stream[1], stream[2]}, forest.ShinglePoints())
+
+		_, err := forest.Update(3, []float64{1, 2, 3})
+		assert.True(t, errors.Is(err, rrcf.ErrDimensionMismatch), "Accepted point of wrong dimension: %v", err)
+
+		var spike Result
+		for i := 3; i <= 351; i++ {
+			result, err := forest.UpdateResult(i, stream[i])
+			assert.NoError(t, err)
+			if i == 351 {
+				spike = result
+			}
+		}
+		assert.Equal(t, [][]float64{stream[349], stream[350], stream[351]}, forest.ShinglePoints())
+		if mode == ShingleRotating {
+			// Each point is written over the oldest in place
+			assert.Equal(t, append(append(append([]float64(nil), stream[351]...), stream[349]...), stream[350]...), forest.Shingle)
+		}
+		assert.Equal(t, 1, spike.PeakLag(), "Lags do not locate the anomalous point")
+		var total float64
+		for _, lag := range spike.Lags {
+			total += lag
+		}
+		assert.InDelta(t, spike.Score, total, 1e-9, "Lags do not sum to the score")
+
+		forest.ResetShingle()
+		assert.Empty(t, forest.ShinglePoints(), "Shingle not reset")
+		score, err := forest.Update(352, stream[352])
+		assert.NoError(t, err)
+		assert.Equal(t, 0.0, score, "Scored before shingle refilled")
+	}
+
+	// Points are shingled by event time, restarting after a gap
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	forest, _ := NewForest(20, 128, nil, 3, 2)
+	forest.SetShingling(ShingleSliding)
+	forest.SetTimeWindow(TimeWindow{Duration: time.Minute, MaxGap: 2 * time.Second})
+	for i, point := range stream[:100] {
+		_, err := forest.UpdateResultAt(i, start.Add(time.Duration(i)*time.Second), point)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, [][]float64{stream[97], stream[98], stream[99]}, forest.ShinglePoints())
+	assert.Equal(t, 39, forest.Trees[0].InsertionOrder()[0], "Points outside the time window held")
+	result, err := forest.UpdateResultAt(100, start.Add(105*time.Second), stream[100])
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, result.Score, "Shingle not restarted after gap")
+	assert.Equal(t, [][]float64{stream[100]}, forest.ShinglePoints())
+}
+
+func TestP2Quantile(t *testing.T) {
+	rnd := random.NewRandomState(0)
+	values := rnd.Normal2D(20000, 1)
+	sorted := make([]float64, len(values))
+	for i, value := range values {
+		sorted[i] = value[0]
+	}
+	sort.Float64s(sorted)
+
+	for _, p := range []float64{0.5, 0.9, 0.99} {
+		estimate := newP2Quantile(p)
+		assert.True(t, math.IsNaN(estimate.value()), "Estimate before any values")
+		for _, value := range values {
+			estimate.add(value[0])
+		}
+		exact := sorted[int(p*float64(len(sorted)-1))]
+		assert.InDelta(t, exact, estimate.value(), 0.05, fmt.Sprintf("Poor estimate of quantile %v", p))
+	}
+
+	// A few values give the quantile exactly
+	estimate := newP2Quantile(0.5)
+	for _, value := range []float64{3, 1, 2} {
+		estimate.add(value)
+	}
+	assert.Equal(t, 2.0, estimate.value())
+}
+
+func TestThresholder(t *testing.T) {
+	for _, config := range []ThresholdConfig{
+		{Percentile: 100},
+		{Rule: ZScoreRule},
+		{Rule: ThresholdRule(2), Percentile: 99},
+		{Percentile: 99, Window: -1},
+	} {
+		_, err := NewThresholder(config)
+		assert.True(t, errors.Is(err, ErrInvalidParameter), "Accepted config %+v", config)
+	}
+
+	rnd := random.NewRandomState(1)
+	scores := rnd.Normal2D(6000, 1)
+
+	th, _ := NewThresholder(ThresholdConfig{Percentile: 95, WarmUp: 100, Window: 1000})
+	var anomalies int
+	for i, score := range scores[:3000] {
+		grade := th.Grade(score[0])
+		if i < 100 {
+			assert.Equal(t, Grade{}, grade, "Graded during warm-up")
+			continue
+		}
+		assert.GreaterOrEqual(t, grade.Grade, 0.0)
+		assert.Less(t, grade.Grade, 1.0)
+		if grade.Grade > 0 {
+			anomalies++
+		}
+	}
+	assert.InDelta(t, 0.05, float64(anomalies)/2900, 0.01, "Wrong proportion graded as anomalous")
+	assert.InDelta(t, 1.645, th.Threshold(), 0.15, "Wrong 95th percentile")
+
+	// The threshold follows a shift in the scores within two windows
+	for _, score := range scores[3000:] {
+		th.Grade(score[0] + 10)
+	}
+	assert.InDelta(t, 11.645, th.Threshold(), 0.15, "Threshold did not follow the recent scores")
+
+	zscore, _ := NewThresholder(ThresholdConfig{Rule: ZScoreRule, ZScore: 3})
+	for _, score := range scores {
+		zscore.Grade(2*score[0] + 5)
+	}
+	assert.InDelta(t, 11, zscore.Threshold(), 0.2, "Wrong z-score threshold")
+}
+
+func TestForestThreshold(t *testing.T) {
+	forest, _ := NewForest(40, 128, nil, 4, 1)
+	assert.Error(t, forest.SetThreshold(ThresholdConfig{Percentile: 0}))
+	assert.NoError(t, forest.SetThreshold(ThresholdConfig{Percentile: 99, WarmUp: 100}))
+
+	data := sineData(600)
+	var peak float64
+	var normal int
+	for sampleIndex, point := range data {
+		result, err := forest.UpdateResult(sampleIndex, point)
+		assert.NoError(t, err)
+		if sampleIndex < 100+3 {
+			assert.Equal(t, 0.0, result.Threshold, "Graded during warm-up")
+		} else {
+			assert.Greater(t, result.Threshold, 0.0)
+		}
+		if sampleIndex >= 560 && sampleIndex < 575 {
+			peak = math.Max(peak, result.Grade)
+		} else if sampleIndex >= 300 && sampleIndex < 550 && result.Grade > 0 {
+			normal++
+		}
+	}
+	assert.Greater(t, peak, 0.3, "Anomaly not graded")
+	assert.Less(t, normal, 10, "Too many normal points graded as anomalous")
+}
+
+func TestEpisodeTracker(t *testing.T) {
+	_, err := NewEpisodeTracker(EpisodeConfig{Enter: 5, Exit: 10})
+	assert.True(t, errors.Is(err, ErrInvalidParameter), "Accepted exit above enter")
+
+	tracker, _ := NewEpisodeTracker(EpisodeConfig{Enter: 10, Exit: 5, MinDuration: 2, Cooldown: 5})
+	scores := []float64{1, 12, 8, 11, 6, 15, 3, 12, 12, 1, 1, 20, 1, 20, 20}
+	events := make(map[int][]EpisodeEvent)
+	for sampleIndex, score := range scores {
+		if observed := tracker.Observe(sampleIndex, score); observed != nil {
+			events[sampleIndex] = observed
+		}
+	}
+	current, ok := tracker.Current()
+	assert.True(t, ok)
+	assert.Equal(t, 13, current.Start)
+	events[len(scores)] = tracker.Close()
+	_, ok = tracker.Current()
+	assert.False(t, ok, "Episode not closed")
+
+	// An episode is alerted after its minimum duration, continuing while above the exit score
+	assert.Equal(t, map[int][]EpisodeEvent{
+		2:  {{Type: EpisodeStarted, Index: 2, Episode: Episode{Start: 1, Peak: 1, End: 2, PeakScore: 12, Points: 2}}},
+		6:  {{Type: EpisodeEnded, Index: 6, Episode: Episode{Start: 1, Peak: 5, End: 5, PeakScore: 15, Points: 5}}},
+		14: {{Type: EpisodeStarted, Index: 14, Episode: Episode{Start: 13, Peak: 13, End: 14, PeakScore: 20, Points: 2}}},
+		15: {{Type: EpisodeEnded, Index: 14, Episode: Episode{Start: 13, Peak: 13, End: 14, PeakScore: 20, Points: 2}}},
+	}, events, "Wrong episode events")
+
+	data, err := json.Marshal(events[2][0])
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"type":"started"`)
+}
+
+func TestForestEpisodes(t *testing.T) {
+	forest, _ := NewForest(40, 128, nil, 4, 1)
+	assert.NoError(t, forest.SetEpisodes(EpisodeConfig{Enter: 9, Exit: 6, MinDuration: 2, Cooldown: 20}))
+
+	var events []EpisodeEvent
+	for sampleIndex, point := range sineData(600) {
+		result, err := forest.UpdateResult(sampleIndex, point)
+		assert.NoError(t, err)
+		// Ignore the early scores from the trees while filling
+		if sampleIndex >= 128 {
+			events = append(events, result.Events...)
+		}
+	}
+	// Single high scores are not alerted, and the anomaly gives a single episode
+	assert.Len(t, events, 2, fmt.Sprintf("Episodes not grouped: %+v", events))
+	if len(events) == 2 {
+		assert.Equal(t, EpisodeStarted, events[0].Type)
+		assert.Equal(t, EpisodeEnded, events[1].Type)
+		assert.GreaterOrEqual(t, events[1].Episode.Peak, 560)
+		assert.Less(t, events[1].Episode.Peak, 575)
+	}
+}
+
+func TestScoreHistory(t *testing.T) {
+	history := newScoreHistory(100)
+	for i := 0; i < 150; i++ {
+		history.add(float64(i % 100))
+	}
+	// The history holds the 100 most recent scores, 50 to 99 then 0 to 49
+	assert.Len(t, history.sorted, 100)
+	assert.True(t, sort.Float64sAreSorted(history.sorted))
+	assert.Equal(t, 0.5, history.rank(49.5))
+
+	// Calibrated scores rise continuously from the rank, staying below 1
+	previous := 0.0
+	for score := 0.0; score < 200; score += 0.5 {
+		calibrated := history.normalize(score, NormalizeCalibrated)
+		assert.GreaterOrEqual(t, calibrated, previous, fmt.Sprintf("Calibrated score falls at %v", score))
+		assert.Less(t, calibrated, 1.0)
+		previous = calibrated
+	}
+	assert.InDelta(t, history.rank(89), history.calibrate(89), 1e-9)
+	assert.InDelta(t, history.rank(89), history.calibrate(89.001), 1e-3)
+	assert.Greater(t, history.calibrate(120), history.calibrate(100))
+	assert.Equal(t, 1.0, history.rank(100))
+}
+
+// uniformSplitCoDisp returns the mean collusive displacement of a leaf in a tree of n
+// points where each cut divides the points of a branch uniformly at random
+// The first cut above a leaf leaves it with k of m points with probability
+// 2k/(m(m-1)), so the probability G(m, x) that the displacement ratio of the leaf is at
+// most x is the sum of 2k/(m(m-1)) G(k, x) over k of at least m/(1+x). The mean is
+// 1 plus the integral of 1 - G(n, x) from 1 to n, taken on a log scale.
+func uniformSplitCoDisp(n int, steps int) float64 {
+	sums := make([]float64, n+1) // Sums of k G(k, x) for k up to m
+	probability := func(x float64) float64 {
+		sums[1] = 1
+		g := 1.0
+		for m := 2; m <= n; m++ {
+			first := int(math.Max(1, math.Ceil(float64(m)/(1+x)-1e-12)))
+			g = 0
+			if first < m {
+				g = 2 * (sums[m-1] - sums[first-1]) / float64(m*(m-1))
+			}
+			sums[m] = sums[m-1] + float64(m)*g
+		}
+		return 1 - g
+	}
+
+	mean := 1.0
+	previous, above := 1.0, probability(1)
+	for step := 1; step <= steps; step++ {
+		x := math.Exp(math.Log(float64(n)) * float64(step) / float64(steps))
+		next := probability(x)
+		mean += (above + next) / 2 * (x - previous)
+		previous, above = x, next
+	}
+	return mean
+}
+
+func TestExpectedCoDisp(t *testing.T) {
+	// The approximation is exact for the smallest trees, and within 2% of the mean
+	// for trees of 4 to 131072 points
+	assert.Equal(t, 0.0, expectedCoDisp(1))
+	assert.Equal(t, 1.0, expectedCoDisp(2))
+	for n := 4; n <= 131072; n *= 2 {
+		assert.InEpsilon(t, uniformSplitCoDisp(n, 400), expectedCoDisp(float64(n)), 0.02, "Approximation not within 2%% for %d points", n)
+	}
+
+	// Trees of uniformly distributed points are close to the uniform split model
+	rnd := random.NewRandomState(0)
+	for _, n := range []int{16, 64, 256, 1024} {
+		var mean float64
+		trials := 8
+		for trial := 0; trial < trials; trial++ {
+			tree := rrcf.NewRCTree(rnd.UniformArray(0, 1, n, 3), nil, 9, trial)
+			for _, codisp := range tree.CoDispAll() {
+				mean += codisp / float64(n*trials)
+			}
+		}
+		assert.InEpsilon(t, expectedCoDisp(float64(n)), mean, 0.1, "Trees of %d points differ from the approximation", n)
+	}
+}
+
+func TestForestNormalization(t *testing.T) {
+	forest, _ := NewForest(10, 64, nil, 4, 1)
+	assert.True(t, errors.Is(forest.SetNormalization(NormalizeCalibrated+1, 0), ErrInvalidParameter))
+	assert.True(t, errors.Is(forest.SetNormalization(NormalizeScaled, -1), ErrInvalidParameter))
+
+	// Scaled scores divide by the expected score for the size of the trees, so that
+	// typical points score about 1
+	data := sineData(600)
+	for _, treeSize := range []int{64, 256} {
+		forest, _ := NewForest(20, treeSize, nil, 4, 1)
+		assert.NoError(t, forest.SetNormalization(NormalizeScaled, 0))
+		var typical, anomaly float64
+		for sampleIndex, point := range data {
+			result, err := forest.UpdateResult(sampleIndex, point)
+			assert.NoError(t, err)
+			if sampleIndex >= 300 && sampleIndex < 500 {
+				assert.InDelta(t, result.RawScore/expectedCoDisp(float64(treeSize+1)), result.Score, 1e-9)
+				typical += result.Score / 200
+			}
+			if sampleIndex >= 560 && sampleIndex < 575 {
+				anomaly = math.Max(anomaly, result.Score)
+			}
+		}
+		assert.InDelta(t, 1.0, typical, 0.5, "Typical points not scaled to about 1")
+		assert.Greater(t, anomaly, 3*typical, "Anomaly not scaled above typical scores")
+	}
+
+	// Percentile scores lie between 0 and 1, with attribution summing to the score
+	assert.NoError(t, forest.SetNormalization(NormalizePercentile, 100))
+	for sampleIndex, point := range data {
+		result, err := forest.UpdateResult(sampleIndex, point)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, result.Score, 0.0)
+		assert.LessOrEqual(t, result.Score, 1.0)
+		var sum float64
+		for _, value := range result.Attribution {
+			sum += value
+		}
+		assert.InDelta(t, result.Score, sum, 1e-9)
+	}
+	score, err := forest.ScorePoint([]float64{50})
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, score, 1.0)
+	for _, score := range forest.Score() {
+		assert.GreaterOrEqual(t, score, 0.0)
+		assert.LessOrEqual(t, score, 1.0)
+	}
+	for _, result := range forest.ScoreResults() {
+		assert.LessOrEqual(t, result.Score, 1.0)
+		assert.GreaterOrEqual(t, result.RawScore, result.Score)
+	}
+}
+
+func TestForestScorers(t *testing.T) {
+	forest, _ := NewForest(10, 64, nil, 4, 1)
+	assert.True(t, errors.Is(forest.SetScorer(nil), ErrInvalidParameter))
+
+	data := sineData(600)
+	scorers := map[string]Scorer[int]{
+		"codisp":    CoDispScorer[int](),
+		"isolation": IsolationScorer[int](),
+		"mass":      MassScorer[int](),
+		"bestdisp":  BestDispScorer[int](),
+		"minmass":   MinMassCoDispScorer[int](8),
+	}
+	for name, scorer := range scorers {
+		forest, _ := NewForest(20, 128, nil, 4, 1)
+		assert.NoError(t, forest.SetScorer(scorer))
+		var peak, normal float64
+		for sampleIndex, point := range data {
+			result, err := forest.UpdateResult(sampleIndex, point)
+			assert.NoError(t, err)
+			var sum float64
+			for _, value := range result.Attribution {
+				sum += value
+			}
+			assert.InDelta(t, result.Score, sum, 1e-9, "Attribution does not sum to %s score", name)
+			if sampleIndex >= 560 && sampleIndex < 575 {
+				peak = math.Max(peak, result.Score)
+			} else if sampleIndex >= 300 && sampleIndex < 550 {
+				normal += result.Score / 250
+			}
+		}
+		assert.Greater(t, peak, 1.1*normal, "Anomaly not scored highly by %s", name)
+
+		for key, score := range forest.Score() {
+			treeScore, err := forest.GetScore(0, key)
+			if err == nil {
+				assert.GreaterOrEqual(t, treeScore, 0.0)
+			}
+			assert.GreaterOrEqual(t, score, 0.0)
+		}
+		_, err := forest.ScorePoint([]float64{50})
+		if name == "codisp" {
+			assert.NoError(t, err)
+		} else {
+			assert.True(t, errors.Is(err, ErrInvalidParameter), "Scored point with %s: %v", name, err)
+		}
+	}
+}
+
+func TestAggregation(t *testing.T) {
+	scores := []float64{4, 1, 3, 2, 100}
+	assert.Equal(t, 22.0, AggregationConfig{}.aggregate(scores))
+	assert.Equal(t, 3.0, AggregationConfig{Method: AggregateMedian}.aggregate(scores))
+	assert.Equal(t, 3.0, AggregationConfig{Method: AggregateTrimmedMean, Trim: 0.2}.aggregate(scores))
+	assert.Equal(t, 100.0, AggregationConfig{Method: AggregateMax}.aggregate(scores))
+	assert.Equal(t, 3.5, AggregationConfig{Method: AggregateQuantile, Quantile: 0.625}.aggregate(scores))
+	assert.Equal(t, 1.0, AggregationConfig{Method: AggregateQuantile, Quantile: 0}.aggregate(scores))
+	assert.Equal(t, []float64{4, 1, 3, 2, 100}, scores, "Scores reordered")
+	assert.InDelta(t, 1902.5, variance(scores), 1e-9)
+
+	forest, _ := NewForest(20, 64, nil, 4, 1)
+	assert.True(t, errors.Is(forest.SetAggregation(AggregationConfig{Method: AggregateTrimmedMean, Trim: 0.5}), ErrInvalidParameter))
+	assert.True(t, errors.Is(forest.SetAggregation(AggregationConfig{Method: AggregateQuantile, Quantile: 1.5}), ErrInvalidParameter))
+	assert.True(t, errors.Is(forest.SetAggregation(AggregationConfig{Method: AggregateQuantile + 1}), ErrInvalidParameter))
+
+	// The mean of the tree scores gives the score, within its confidence interval
+	assert.NoError(t, forest.SetAggregation(AggregationConfig{TreeScores: true}))
+	data := sineData(300)
+	for sampleIndex, point := range data[:200] {
+		result, err := forest.UpdateResult(sampleIndex, point)
+		assert.NoError(t, err)
+		if result.Point == nil {
+			// The shingle is not yet filled
+			continue
+		}
+		assert.Len(t, result.TreeScores, 20)
+		assert.InDelta(t, mean(result.TreeScores), result.Score, 1e-9)
+		low, high := result.ConfidenceInterval(1.96)
+		assert.LessOrEqual(t, low, result.Score)
+		assert.GreaterOrEqual(t, high, result.Score)
+		if sampleIndex > 10 {
+			assert.Greater(t, result.Variance, 0.0)
+		}
+	}
+
+	// The median is no more than the maximum, with attribution summing to each
+	for _, method := range []Aggregation{AggregateMedian, AggregateMax} {
+		assert.NoError(t, forest.SetAggregation(AggregationConfig{Method: method, TreeScores: true}))
+		result, err := forest.UpdateResult(200+int(method), data[200+int(method)])
+		assert.NoError(t, err)
+		var sum float64
+		for _, value := range result.Attribution {
+			sum += value
+		}
+		assert.InDelta(t, result.Score, sum, 1e-9)
+		assert.Equal(t, AggregationConfig{Method: method}.aggregate(result.TreeScores), result.Score)
+
+		score, err := forest.ScorePoint(data[250])
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, score, 0.0)
+	}
+	results := forest.ScoreResults()
+	for key, score := range forest.Score() {
+		assert.Equal(t, score, results[key].Score)
+		highest := math.Inf(-1)
+		for _, treeScore := range results[key].TreeScores {
+			highest = math.Max(highest, treeScore)
+		}
+		assert.Equal(t, highest, score)
+	}
+}
+
+func TestScorePointAnytime(t *testing.T) {
+	forest, _ := NewForest(100, 128, nil, 1, 1)
+	for sampleIndex, point := range sineData(400)[:300] {
+		_, err := forest.Update(sampleIndex, point)
+		assert.NoError(t, err)
+	}
+	_, err := forest.ScorePointAnytime([]float64{50}, AnytimeConfig{Threshold: 10, Confidence: 1})
+	assert.True(t, errors.Is(err, ErrInvalidParameter))
+	_, err = forest.ScorePointAnytime([]float64{50}, AnytimeConfig{Threshold: 10, Confidence: 0.9, MinTrees: 1})
+	assert.True(t, errors.Is(err, ErrInvalidParameter))
+
+	// Clearly normal and clearly anomalous points are decided with few trees
+	config := AnytimeConfig{Threshold: 15, Confidence: 0.99}
+	normal, err := forest.ScorePointAnytime([]float64{50}, config)
+	assert.NoError(t, err)
+	assert.True(t, normal.Decided)
+	assert.False(t, normal.Anomalous)
+	assert.Less(t, normal.Trees, 50, "Normal point not decided early")
+
+	anomaly, err := forest.ScorePointAnytime([]float64{200}, config)
+	assert.NoError(t, err)
+	assert.True(t, anomaly.Decided)
+	assert.True(t, anomaly.Anomalous)
+	assert.Less(t, anomaly.Trees, 50, "Anomaly not decided early")
+
+	// Scoring is repeatable, and a point at the threshold is scored by every tree
+	repeated, _ := forest.ScorePointAnytime([]float64{200}, config)
+	assert.Equal(t, anomaly, repeated)
+	score, _ := forest.ScorePoint([]float64{65})
+	config.Threshold = score
+	border, err := forest.ScorePointAnytime([]float64{65}, config)
+	assert.NoError(t, err)
+	assert.False(t, border.Decided)
+	assert.Equal(t, 100, border.Trees)
+	assert.InDelta(t, score, border.Score, 1e-9)
+
+	config.MaxTrees = 20
+	border, _ = forest.ScorePointAnytime([]float64{65}, config)
+	assert.Equal(t, 20, border.Trees)
+}
+
+func BenchmarkUpdateForest(b *testing.B) {
+	for _, treeSize := range []int{256, 1024, 4096} {
+		b.Run(fmt.Sprintf("TreeSize%d", treeSize), func(b *testing.B) {
+			rnd := random.NewRandomState(0)
+			data := rnd.Normal2D(2*treeSize, 3)
+			forest, _ := NewForest(10, treeSize, nil, 1, 0)
+			// Fill the trees, so that each update also forgets a point
+			for sampleIndex, point := range data[:treeSize] {
+				forest.Update(sampleIndex, point)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				sampleIndex := treeSize + i
+				forest.Update(sampleIndex, data[sampleIndex%len(data)])
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package forest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/andysgithub/go-rrcf/rrcf"
+)
+
+// Impute returns the most plausible completion of a point whose missing dimensions
+// are set to NaN
+// Each tree imputes the missing values from the leaves consistent with the known
+// values, and the median across the trees is returned
+func (f *Forest[K]) Impute(point []float64) ([]float64, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.impute(point)
+}
+
+func (f *Forest[K]) impute(point []float64) ([]float64, error) {
+	if len(point) == 0 {
+		return nil, fmt.Errorf("%w: point (0)", rrcf.ErrDimensionMismatch)
+	}
+	if !hasMissing(point) {
+		return append([]float64(nil), point...), nil
+	}
+
+	// Impute the point in each tree in parallel
+	treeImputed := make([][]float64, len(f.Trees))
+	err := f.forEachTree(len(f.Trees), func(treeIndex int) error {
+		var err error
+		treeImputed[treeIndex], err = f.Trees[treeIndex].Impute(point)
+		if errors.Is(err, rrcf.ErrEmptyTree) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	imputed := append([]float64(nil), point...)
+	values := make([]float64, 0, len(f.Trees))
+	for dim, value := range point {
+		if !math.IsNaN(value) {
+			continue
+		}
+		values = values[:0]
+		for _, treePoint := range treeImputed {
+			if treePoint != nil {
+				values = append(values, treePoint[dim])
+			}
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("%w: no points to impute from", rrcf.ErrEmptyTree)
+		}
+		imputed[dim] = median(values)
+	}
+	return imputed, nil
+}
+
+// hasMissing reports whether any value of a point is NaN
+func hasMissing(point []float64) bool {
+	for _, value := range point {
+		if math.IsNaN(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// median returns the median of the values, reordering them in place
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	middle := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[middle-1] + values[middle]) / 2
+	}
+	return values[middle]
+}
@@ -0,0 +1,220 @@
+package forest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Normalization sets how the scores of a forest are normalized, so that one threshold
+// can be used across forests of different sizes and data
+type Normalization int
+
+const (
+	// NormalizeNone gives the raw collusive displacement
+	NormalizeNone Normalization = iota
+	// NormalizeScaled divides a collusive displacement by its expected value for a point
+	// in trees of the current number of leaves, so that a typical point scores about 1
+	NormalizeScaled
+	// NormalizePercentile gives the proportion of the recent scores at or below the score
+	NormalizePercentile
+	// NormalizeCalibrated gives a probability-like score from 0 to 1, from the percentile
+	// of the score with an exponential tail fitted to the highest tenth of the recent
+	// scores, so that it continues to rise beyond the highest score seen
+	NormalizeCalibrated
+)
+
+// tailQuantile is the quantile of the recent scores above which the tail is fitted
+const tailQuantile = 0.9
+
+// SetNormalization sets how the scores of the forest are normalized
+// Streaming scores are normalized against the given number of recent scores, or
+// TreeSize if 0, before any threshold or episode tracking. Scores for all points,
+// from Score and ScoreResults, are normalized against each other. Scaled scores
+// depend only on the number of leaves in the trees.
+func (f *Forest[K]) SetNormalization(normalization Normalization, history int) error {
+	if normalization < NormalizeNone || normalization > NormalizeCalibrated || history < 0 {
+		return fmt.Errorf("%w: normalization (%d), history (%d)", ErrInvalidParameter, normalization, history)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if history == 0 {
+		history = f.TreeSize
+	}
+	f.normalization = normalization
+	f.scoreHistory = newScoreHistory(history)
+	return nil
+}
+
+// normalize normalizes the score of a result as set for the forest, then adds
+// the raw score to the recent scores
+func (f *Forest[K]) normalize(result *Result) {
+	if f.normalization == NormalizeNone {
+		return
+	}
+	result.RawScore = result.Score
+	result.scale(f.normalizedScore(result.Score))
+	f.scoreHistory.add(result.RawScore)
+}
+
+// normalizedScore normalizes a score as set for the forest, without adding it
+// to the recent scores
+func (f *Forest[K]) normalizedScore(score float64) float64 {
+	switch f.normalization {
+	case NormalizeNone:
+		return score
+	case NormalizeScaled:
+		return f.scaledScore(score)
+	}
+	return f.scoreHistory.normalize(score, f.normalization)
+}
+
+// normalizeAll normalizes a set of scores against each other
+func (f *Forest[K]) normalizeAll(scores []float64) []float64 {
+	normalized := make([]float64, len(scores))
+	if f.normalization == NormalizeScaled {
+		for i, score := range scores {
+			normalized[i] = f.scaledScore(score)
+		}
+		return normalized
+	}
+	history := newScoreHistory(len(scores))
+	for _, score := range scores {
+		history.add(score)
+	}
+	for i, score := range scores {
+		normalized[i] = history.normalize(score, f.normalization)
+	}
+	return normalized
+}
+
+// scaledScore divides a score by the expected collusive displacement of a point in
+// trees holding the mean number of leaves of the trees
+// Scores from scorers other than CoDispScorer and MinMassCoDispScorer are unchanged.
+func (f *Forest[K]) scaledScore(score float64) float64 {
+	switch f.scorer.(type) {
+	case nil, coDispScorer[K], minMassCoDispScorer[K]:
+	default:
+		return score
+	}
+	var leaves int
+	for treeIndex := range f.Trees {
+		leaves += len(f.Trees[treeIndex].Leaves)
+	}
+	expected := expectedCoDisp(float64(leaves) / float64(len(f.Trees)))
+	if expected == 0 {
+		return 0
+	}
+	return score / expected
+}
+
+// expectedCoDisp approximates the mean collusive displacement of a leaf in a tree of
+// n points, where each cut divides the points of a branch uniformly at random
+// The constants are a least-squares fit of a + b*sqrt(ln n) + c/sqrt(ln n) to the mean
+// computed from the recursion for the sizes of the branches above a leaf. The fit is
+// within 2% of that mean for trees of 4 to 131072 points, and exact for trees of fewer
+// than 3 points. Trees of uniformly spread points come close to that mean, as checked
+// in TestExpectedCoDisp, while clustered data gives higher scores.
+func expectedCoDisp(n float64) float64 {
+	if n < 2 {
+		return 0
+	}
+	root := math.Sqrt(math.Log(n))
+	return math.Max(1, 2.7358*root+0.8322/root-2.4546)
+}
+
+// scale sets the score of a result, scaling the attribution, lags and tree scores to match
+func (r *Result) scale(score float64) {
+	if r.Score != 0 {
+		ratio := score / r.Score
+		for dim := range r.Attribution {
+			r.Attribution[dim] *= ratio
+		}
+		for lag := range r.Lags {
+			r.Lags[lag] *= ratio
+		}
+		for tree := range r.TreeScores {
+			r.TreeScores[tree] *= ratio
+		}
+		r.Variance *= ratio * ratio
+	}
+	r.Score = score
+}
+
+// scoreHistory records the most recent scores, in arrival order and sorted
+type scoreHistory struct {
+	recent []float64 // Recent scores as a ring buffer
+	next   int       // Position in the ring buffer of the next score
+	sorted []float64 // Recent scores in ascending order
+}
+
+func newScoreHistory(size int) *scoreHistory {
+	return &scoreHistory{recent: make([]float64, 0, size)}
+}
+
+// add adds a score, dropping the oldest score once the history is full
+func (h *scoreHistory) add(score float64) {
+	if len(h.recent) < cap(h.recent) {
+		h.recent = append(h.recent, score)
+	} else {
+		oldest := h.recent[h.next]
+		h.recent[h.next] = score
+		h.next = (h.next + 1) % len(h.recent)
+		i := sort.SearchFloat64s(h.sorted, oldest)
+		h.sorted = append(h.sorted[:i], h.sorted[i+1:]...)
+	}
+	i := sort.SearchFloat64s(h.sorted, score)
+	h.sorted = append(h.sorted, 0)
+	copy(h.sorted[i+1:], h.sorted[i:])
+	h.sorted[i] = score
+}
+
+// normalize returns a score normalized against the history, or 0 if the history is empty
+func (h *scoreHistory) normalize(score float64, normalization Normalization) float64 {
+	if len(h.sorted) == 0 {
+		return 0
+	}
+	switch normalization {
+	case NormalizePercentile:
+		return h.rank(score)
+	case NormalizeCalibrated:
+		return h.calibrate(score)
+	}
+	return score
+}
+
+// rank returns the proportion of the history at or below the score
+func (h *scoreHistory) rank(score float64) float64 {
+	below := sort.Search(len(h.sorted), func(i int) bool {
+		return h.sorted[i] > score
+	})
+	return float64(below) / float64(len(h.sorted))
+}
+
+// calibrate returns the rank of the score, with an exponential tail beyond the tail quantile
+// The tail falls with the mean excess of the history over the tail quantile, as in
+// a peaks-over-threshold fit, and joins the rank at the tail quantile
+func (h *scoreHistory) calibrate(score float64) float64 {
+	n := len(h.sorted)
+	start := int(tailQuantile * float64(n-1))
+	threshold := h.sorted[start]
+	if score <= threshold {
+		return h.rank(score)
+	}
+
+	var excess float64
+	var count int
+	for _, value := range h.sorted[start:] {
+		if value > threshold {
+			excess += value - threshold
+			count++
+		}
+	}
+	if count == 0 {
+		// The score is beyond every score in the history
+		return 1
+	}
+	tail := 1 - h.rank(threshold)
+	return 1 - tail*math.Exp(-(score-threshold)/(excess/float64(count)))
+}
@@ -0,0 +1,104 @@
+package forest
+
+import (
+	"math"
+	"sort"
+)
+
+// p2Quantile estimates a quantile of a stream in constant memory, using the P²
+// algorithm of Jain and Chlamtac
+// Five markers track the minimum, the quantile, the maximum and the quantiles
+// midway between them, and are moved towards their desired positions by
+// piecewise-parabolic interpolation as each value arrives.
+type p2Quantile struct {
+	p         float64    // Quantile to estimate, from 0 to 1
+	count     int        // Number of values seen
+	heights   [5]float64 // Marker heights
+	positions [5]float64 // Marker positions
+	desired   [5]float64 // Desired marker positions
+	increment [5]float64 // Increase in the desired positions for each value
+}
+
+func newP2Quantile(p float64) *p2Quantile {
+	return &p2Quantile{
+		p:         p,
+		increment: [5]float64{0, p / 2, p, (1 + p) / 2, 1},
+	}
+}
+
+// add includes a value in the estimate
+func (e *p2Quantile) add(x float64) {
+	if e.count < 5 {
+		e.heights[e.count] = x
+		e.count++
+		if e.count == 5 {
+			sort.Float64s(e.heights[:])
+			e.positions = [5]float64{1, 2, 3, 4, 5}
+			e.desired = [5]float64{1, 1 + 2*e.p, 1 + 4*e.p, 3 + 2*e.p, 5}
+		}
+		return
+	}
+	e.count++
+
+	// Find the cell holding the value, extending the extremes if needed
+	var cell int
+	switch {
+	case x < e.heights[0]:
+		e.heights[0] = x
+		cell = 0
+	case x >= e.heights[4]:
+		e.heights[4] = x
+		cell = 3
+	default:
+		for cell = 0; x >= e.heights[cell+1]; cell++ {
+		}
+	}
+	for i := cell + 1; i < 5; i++ {
+		e.positions[i]++
+	}
+	for i := range e.desired {
+		e.desired[i] += e.increment[i]
+	}
+
+	// Adjust the middle markers if they are off their desired positions
+	for i := 1; i < 4; i++ {
+		d := e.desired[i] - e.positions[i]
+		if (d >= 1 && e.positions[i+1]-e.positions[i] > 1) || (d <= -1 && e.positions[i-1]-e.positions[i] < -1) {
+			step := math.Copysign(1, d)
+			height := e.parabolic(i, step)
+			if e.heights[i-1] < height && height < e.heights[i+1] {
+				e.heights[i] = height
+			} else {
+				e.heights[i] = e.linear(i, step)
+			}
+			e.positions[i] += step
+		}
+	}
+}
+
+// parabolic predicts the height of marker i moved by one step
+func (e *p2Quantile) parabolic(i int, step float64) float64 {
+	n, q := e.positions, e.heights
+	return q[i] + step/(n[i+1]-n[i-1])*((n[i]-n[i-1]+step)*(q[i+1]-q[i])/(n[i+1]-n[i])+
+		(n[i+1]-n[i]-step)*(q[i]-q[i-1])/(n[i]-n[i-1]))
+}
+
+// linear predicts the height of marker i moved by one step towards its neighbour
+func (e *p2Quantile) linear(i int, step float64) float64 {
+	j := i + int(step)
+	return e.heights[i] + step*(e.heights[j]-e.heights[i])/(e.positions[j]-e.positions[i])
+}
+
+// value returns the estimate of the quantile, or NaN if no values have been seen
+// Until five values are seen, the quantile is taken from the values directly
+func (e *p2Quantile) value() float64 {
+	if e.count == 0 {
+		return math.NaN()
+	}
+	if e.count < 5 {
+		values := append([]float64(nil), e.heights[:e.count]...)
+		sort.Float64s(values)
+		return values[int(math.Round(e.p*float64(e.count-1)))]
+	}
+	return e.heights[2]
+}
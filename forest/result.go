@@ -0,0 +1,94 @@
+package forest
+
+import "math"
+
+// Result records the score of a point and the detail behind it
+type Result struct {
+	Score       float64        // Average collusive displacement across the trees, normalized if set
+	RawScore    float64        // Average collusive displacement before normalization, if set
+	Attribution []float64      // Contribution of each dimension of the point to the score
+	Lags        []float64      // Contribution of each point in the shingle by lag, newest first, for a shingled stream
+	Point       []float64      // Point inserted into the trees, with any missing values imputed
+	Threshold   float64        // Threshold the score was graded against, or 0 if not graded
+	Grade       float64        // Anomaly grade from 0 to 1, above 0 when the score exceeds the threshold
+	Events      []EpisodeEvent // Episode events caused by the point, if tracking episodes
+	TreeScores  []float64      // Score from each tree holding the point, if kept
+	Variance    float64        // Sample variance of the tree scores, if kept
+}
+
+// ConfidenceInterval returns the interval of z standard errors either side of the
+// score, from the variance of the tree scores
+// The interval is for the mean of the tree scores, and is empty unless tree scores are kept
+func (r Result) ConfidenceInterval(z float64) (float64, float64) {
+	if len(r.TreeScores) == 0 {
+		return r.Score, r.Score
+	}
+	margin := z * math.Sqrt(r.Variance/float64(len(r.TreeScores)))
+	return r.Score - margin, r.Score + margin
+}
+
+// PeakLag returns the lag of the shingle value contributing most to the score,
+// where lag 0 is the newest value
+// Returns -1 if the result has no lags
+func (r Result) PeakLag() int {
+	peak := -1
+	for lag, value := range r.Lags {
+		if peak < 0 || value > r.Lags[peak] {
+			peak = lag
+		}
+	}
+	return peak
+}
+
+// addResult adds the share of a single tree's score and attribution to the result,
+// for the average over the given number of trees
+func (r *Result) addResult(score float64, attribution []float64, trees float64) {
+	r.Score += score / trees
+	if r.Attribution == nil {
+		r.Attribution = make([]float64, len(attribution))
+	}
+	for dim, value := range attribution {
+		r.Attribution[dim] += value / trees
+	}
+}
+
+// assess normalizes and grades the score of a point inserted into the trees and tracks
+// its episode, as set for the forest
+func (f *Forest[K]) assess(sampleIndex K, result *Result) {
+	f.updates++
+	if result.Point == nil {
+		return
+	}
+	f.normalize(result)
+	if f.thresholder != nil {
+		grade := f.thresholder.Grade(result.Score)
+		result.Threshold = grade.Threshold
+		result.Grade = grade.Grade
+	}
+	if f.episodes != nil {
+		result.Events = f.episodes.Observe(f.episodeIndex(sampleIndex), result.Score)
+	}
+}
+
+// episodeIndex returns the index of a point recorded in its episode: the sample index
+// for integer labels, or the number of points updated before it for other labels
+func (f *Forest[K]) episodeIndex(sampleIndex K) int {
+	if index, ok := any(sampleIndex).(int); ok {
+		return index
+	}
+	return f.updates - 1
+}
+
+// lagsFromShingle returns the attribution of a shingle of the given size summed over
+// each point and ordered by lag, newest first, given the block holding the newest point
+func lagsFromShingle(attribution []float64, size int, newest int) []float64 {
+	lags := make([]float64, size)
+	dims := len(attribution) / size
+	for block := 0; block < size; block++ {
+		lag := (newest - block + size) % size
+		for _, value := range attribution[block*dims : (block+1)*dims] {
+			lags[lag] += value
+		}
+	}
+	return lags
+}
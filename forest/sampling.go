@@ -0,0 +1,293 @@
+package forest
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/andysgithub/go-rrcf/random"
+	"github.com/andysgithub/go-rrcf/rrcf"
+)
+
+// Sampler decides which points are kept in a tree when streaming
+// Each tree has its own Sampler, so a Sampler is never called concurrently
+//
+// The tree is the record of the points kept. A Sampler is told of each point the
+// tree has taken through Commit, and of each point removed from the tree by other
+// means, such as a time window or ForgetPoint, through Forget.
+type Sampler[K comparable] interface {
+	// Sample decides whether the tree keeps a new point, and returns the indices
+	// of any points held in the tree to forget to make room for it
+	// The decision takes effect only when passed to Commit
+	Sample(tree *rrcf.Tree[K], sampleIndex K, treeSize int) (keep bool, forget []K)
+	// Commit records the decision for a point once it has been inserted into the tree
+	Commit(sampleIndex K, keep bool, forget []K)
+	// Forget records that a point has been removed from the tree other than by the Sampler
+	Forget(index K)
+}
+
+// SamplingPolicy creates the Sampler for each tree, given a seed drawn from the forest
+type SamplingPolicy[K comparable] func(seed int64) Sampler[K]
+
+// FIFOSampling keeps the most recent TreeSize points in every tree
+func FIFOSampling[K comparable]() SamplingPolicy[K] {
+	return func(seed int64) Sampler[K] {
+		return fifoSampler[K]{}
+	}
+}
+
+// ReservoirSampling keeps a uniform random sample of all points seen by each tree
+func ReservoirSampling[K comparable]() SamplingPolicy[K] {
+	return func(seed int64) Sampler[K] {
+		return &reservoirSampler[K]{rng: random.NewRandomState(seed)}
+	}
+}
+
+// DecayedSampling keeps a random sample of the points seen by each tree, weighted
+// towards recent points
+// The weight of a point falls by a factor of exp(-decay) with each later point,
+// so a decay of 0 gives a uniform sample and larger decays approach FIFO
+func DecayedSampling[K comparable](decay float64) SamplingPolicy[K] {
+	return func(seed int64) Sampler[K] {
+		return &decayedSampler[K]{rng: random.NewRandomState(seed), decay: decay}
+	}
+}
+
+// SetSampling sets the policy for the points kept in each tree when streaming
+// Each tree is given its own Sampler, seeded from the forest
+func (f *Forest[K]) SetSampling(policy SamplingPolicy[K]) error {
+	if policy == nil {
+		return fmt.Errorf("%w: no sampling policy", ErrInvalidParameter)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sampling = policy
+	f.Samplers = nil
+	f.prepareSamplers()
+	return nil
+}
+
+// prepareSamplers creates a Sampler for any tree without one
+func (f *Forest[K]) prepareSamplers() {
+	if f.sampling == nil {
+		f.sampling = FIFOSampling[K]()
+	}
+	for len(f.Samplers) < len(f.Trees) {
+		f.Samplers = append(f.Samplers, f.sampling(f.Rng.Int63()))
+	}
+}
+
+// fifoSampler keeps every new point, dropping the earliest inserted points in the
+// tree once the tree is above the permitted size
+// The tree records its own insertion order, so sample indices need not be sequential
+type fifoSampler[K comparable] struct{}
+
+func (fifoSampler[K]) Sample(tree *rrcf.Tree[K], sampleIndex K, treeSize int) (bool, []K) {
+	// If tree is above permitted size, drop the oldest points beyond it
+	excess := len(tree.Leaves) - treeSize
+	if excess == 1 {
+		if oldest, ok := tree.Oldest(); ok {
+			return true, []K{oldest}
+		}
+	} else if excess > 1 {
+		return true, tree.InsertionOrder()[:excess]
+	}
+	return true, nil
+}
+
+func (fifoSampler[K]) Commit(sampleIndex K, keep bool, forget []K) {}
+
+func (fifoSampler[K]) Forget(index K) {}
+
+// reservoirSampler keeps each of the points seen with equal probability
+type reservoirSampler[K comparable] struct {
+	rng       *random.RandomState
+	seen      int       // Number of points offered to the tree
+	members   []K       // Indices of the points kept in the tree
+	positions map[K]int // Position of each index in the members
+}
+
+func (s *reservoirSampler[K]) Sample(tree *rrcf.Tree[K], sampleIndex K, treeSize int) (bool, []K) {
+	s.sync(tree)
+	if len(s.members) < treeSize {
+		return true, nil
+	}
+	// Keep the point with probability treeSize/seen, in place of a random member
+	// and any members beyond the permitted size
+	if s.rng.Intn(s.seen+1) >= treeSize {
+		return false, nil
+	}
+	forget := make([]K, 0, len(s.members)-treeSize+1)
+	chosen := make(map[int]bool, cap(forget))
+	for len(forget) < cap(forget) {
+		position := s.rng.Intn(len(s.members))
+		if !chosen[position] {
+			chosen[position] = true
+			forget = append(forget, s.members[position])
+		}
+	}
+	return true, forget
+}
+
+func (s *reservoirSampler[K]) Commit(sampleIndex K, keep bool, forget []K) {
+	s.seen++
+	for _, index := range forget {
+		s.Forget(index)
+	}
+	if keep {
+		s.positions[sampleIndex] = len(s.members)
+		s.members = append(s.members, sampleIndex)
+	}
+}
+
+func (s *reservoirSampler[K]) Forget(index K) {
+	position, ok := s.positions[index]
+	if !ok {
+		return
+	}
+	last := s.members[len(s.members)-1]
+	s.members[position] = last
+	s.positions[last] = position
+	s.members = s.members[:len(s.members)-1]
+	delete(s.positions, index)
+}
+
+// sync takes the members from the tree if they no longer match it, as when points
+// have been inserted into the tree directly
+func (s *reservoirSampler[K]) sync(tree *rrcf.Tree[K]) {
+	if s.positions != nil && len(s.positions) == len(tree.Leaves) {
+		return
+	}
+	s.members = tree.InsertionOrder()
+	s.positions = make(map[K]int, len(s.members))
+	for position, index := range s.members {
+		s.positions[index] = position
+	}
+	if s.seen < len(s.members) {
+		s.seen = len(s.members)
+	}
+}
+
+// decayedSampler keeps the points with the largest random keys, where the keys of
+// later points are increased so that recent points are more likely to be kept
+type decayedSampler[K comparable] struct {
+	rng     *random.RandomState
+	decay   float64
+	seen    int             // Number of points offered to the tree
+	members *decayedKeys[K] // Keys of the points kept in the tree
+	next    float64         // Key drawn for the point being sampled
+}
+
+func (s *decayedSampler[K]) Sample(tree *rrcf.Tree[K], sampleIndex K, treeSize int) (bool, []K) {
+	s.sync(tree)
+
+	// Sampling with weights exp(decay*seen) is equivalent to keeping the
+	// largest keys of decay*seen plus Gumbel noise
+	s.next = s.key(s.seen + 1)
+	if s.members.Len() < treeSize {
+		return true, nil
+	}
+	if s.next <= s.members.keys[0].key {
+		return false, nil
+	}
+	if s.members.Len() == treeSize {
+		return true, []K{s.members.keys[0].index}
+	}
+
+	// Drop the lowest keys beyond the permitted size as well
+	keys := append([]decayedKey[K](nil), s.members.keys...)
+	sort.Slice(keys, func(i, j int) bool { return keys[i].key < keys[j].key })
+	forget := make([]K, s.members.Len()-treeSize+1)
+	for i := range forget {
+		forget[i] = keys[i].index
+	}
+	return true, forget
+}
+
+func (s *decayedSampler[K]) Commit(sampleIndex K, keep bool, forget []K) {
+	s.seen++
+	for _, index := range forget {
+		s.Forget(index)
+	}
+	if keep {
+		heap.Push(s.members, decayedKey[K]{s.next, sampleIndex})
+	}
+}
+
+func (s *decayedSampler[K]) Forget(index K) {
+	if position, ok := s.members.held(index); ok {
+		heap.Remove(s.members, position)
+	}
+}
+
+// sync takes the members from the tree if they no longer match it, keeping the
+// keys of the points still held and drawing keys for any others
+func (s *decayedSampler[K]) sync(tree *rrcf.Tree[K]) {
+	if s.members != nil && s.members.Len() == len(tree.Leaves) {
+		return
+	}
+	members := &decayedKeys[K]{positions: make(map[K]int, len(tree.Leaves))}
+	for _, index := range tree.InsertionOrder() {
+		key := decayedKey[K]{index: index}
+		if position, ok := s.members.held(index); ok {
+			key.key = s.members.keys[position].key
+		} else {
+			s.seen++
+			key.key = s.key(s.seen)
+		}
+		heap.Push(members, key)
+	}
+	s.members = members
+}
+
+// key returns a random key for the point seen at the given count
+func (s *decayedSampler[K]) key(seen int) float64 {
+	u := s.rng.Uniform(0, 1)
+	for u == 0 {
+		u = s.rng.Uniform(0, 1)
+	}
+	return s.decay*float64(seen) - math.Log(-math.Log(u))
+}
+
+// decayedKey records the random key of a point kept in a tree
+type decayedKey[K comparable] struct {
+	key   float64
+	index K
+}
+
+// decayedKeys is a min-heap of the keys of the points kept in a tree, recording the
+// position of each index in the heap
+type decayedKeys[K comparable] struct {
+	keys      []decayedKey[K]
+	positions map[K]int
+}
+
+// held returns the position of an index in the heap, if held
+func (k *decayedKeys[K]) held(index K) (int, bool) {
+	if k == nil {
+		return 0, false
+	}
+	position, ok := k.positions[index]
+	return position, ok
+}
+
+func (k *decayedKeys[K]) Len() int           { return len(k.keys) }
+func (k *decayedKeys[K]) Less(i, j int) bool { return k.keys[i].key < k.keys[j].key }
+func (k *decayedKeys[K]) Swap(i, j int) {
+	k.keys[i], k.keys[j] = k.keys[j], k.keys[i]
+	k.positions[k.keys[i].index] = i
+	k.positions[k.keys[j].index] = j
+}
+func (k *decayedKeys[K]) Push(x interface{}) {
+	key := x.(decayedKey[K])
+	k.positions[key.index] = len(k.keys)
+	k.keys = append(k.keys, key)
+}
+func (k *decayedKeys[K]) Pop() interface{} {
+	key := k.keys[len(k.keys)-1]
+	k.keys = k.keys[:len(k.keys)-1]
+	delete(k.positions, key.index)
+	return key
+}
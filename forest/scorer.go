@@ -0,0 +1,174 @@
+package forest
+
+import (
+	"fmt"
+
+	"github.com/andysgithub/go-rrcf/rrcf"
+)
+
+// Scorer scores a leaf of a tree, with higher scores for more anomalous points
+// A Scorer is shared by all trees, so it may be called concurrently
+type Scorer[K comparable] interface {
+	// Score returns the score of the leaf with the given index
+	Score(tree *rrcf.Tree[K], sampleIndex K) (float64, error)
+}
+
+// PointScorer is a Scorer that can also score a point without inserting it into a tree
+type PointScorer[K comparable] interface {
+	Scorer[K]
+	// ScorePoint returns the expected score of the point if it were inserted
+	ScorePoint(tree *rrcf.Tree[K], point []float64) (float64, error)
+}
+
+// TreeScorer is a Scorer that can score every leaf of a tree in one pass
+type TreeScorer[K comparable] interface {
+	Scorer[K]
+	// ScoreTree returns the score of every leaf in the tree, keyed by index
+	ScoreTree(tree *rrcf.Tree[K]) map[K]float64
+}
+
+// CoDispScorer scores leaves by collusive displacement, the default for a forest
+func CoDispScorer[K comparable]() Scorer[K] {
+	return coDispScorer[K]{}
+}
+
+// IsolationScorer scores leaves by their depth normalized as in isolation forest,
+// from near 0 for points deep in the trees to near 1 for points isolated early
+func IsolationScorer[K comparable]() Scorer[K] {
+	return isolationScorer[K]{}
+}
+
+// MassScorer scores leaves by the relative mass of the region around them
+func MassScorer[K comparable]() Scorer[K] {
+	return massScorer[K]{}
+}
+
+// BestDispScorer scores leaves by their displacement at the level giving their
+// collusive displacement
+func BestDispScorer[K comparable]() Scorer[K] {
+	return bestDispScorer[K]{}
+}
+
+// MinMassCoDispScorer scores leaves by collusive displacement, ignoring ancestors
+// holding fewer than minMass points
+func MinMassCoDispScorer[K comparable](minMass int) Scorer[K] {
+	return minMassCoDispScorer[K]{minMass: minMass}
+}
+
+// SetScorer sets how the leaves of the trees are scored by UpdateForest, Score and
+// ScoreResults
+// ScorePoint needs a PointScorer, such as CoDispScorer
+func (f *Forest[K]) SetScorer(scorer Scorer[K]) error {
+	if scorer == nil {
+		return fmt.Errorf("%w: no scorer", ErrInvalidParameter)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.scorer = scorer
+	return nil
+}
+
+// scoreLeaf scores a leaf of a tree with the forest's Scorer
+func (f *Forest[K]) scoreLeaf(tree *rrcf.Tree[K], sampleIndex K) (float64, error) {
+	if f.scorer == nil {
+		return tree.CoDisp(sampleIndex)
+	}
+	return f.scorer.Score(tree, sampleIndex)
+}
+
+// scoreTree scores every leaf of a tree with the forest's Scorer, in one pass if the
+// Scorer is a TreeScorer
+func (f *Forest[K]) scoreTree(tree *rrcf.Tree[K]) map[K]float64 {
+	scorer := f.scorer
+	if scorer == nil {
+		scorer = coDispScorer[K]{}
+	}
+	if treeScorer, ok := scorer.(TreeScorer[K]); ok {
+		return treeScorer.ScoreTree(tree)
+	}
+	scores := make(map[K]float64, len(tree.Leaves))
+	for key := range tree.Leaves {
+		scores[key], _ = scorer.Score(tree, key)
+	}
+	return scores
+}
+
+// scoreResult scores a leaf of a tree with the forest's Scorer, together with the
+// contribution of each dimension of the leaf to its score
+// The attribution of the codisp is scaled to sum to the score
+func (f *Forest[K]) scoreResult(tree *rrcf.Tree[K], sampleIndex K) (float64, []float64, error) {
+	score, err := f.scoreLeaf(tree, sampleIndex)
+	if err != nil {
+		return 0, nil, err
+	}
+	attribution, err := tree.Attribution(sampleIndex)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, ok := f.scorer.(coDispScorer[K]); f.scorer != nil && !ok {
+		var total float64
+		for _, value := range attribution {
+			total += value
+		}
+		if total > 0 {
+			for dim := range attribution {
+				attribution[dim] *= score / total
+			}
+		}
+	}
+	return score, attribution, nil
+}
+
+// pointScorer returns the forest's Scorer for scoring points without inserting them
+func (f *Forest[K]) pointScorer() (PointScorer[K], error) {
+	if f.scorer == nil {
+		return coDispScorer[K]{}, nil
+	}
+	scorer, ok := f.scorer.(PointScorer[K])
+	if !ok {
+		return nil, fmt.Errorf("%w: scorer %T cannot score points without inserting them", ErrInvalidParameter, f.scorer)
+	}
+	return scorer, nil
+}
+
+type coDispScorer[K comparable] struct{}
+
+func (coDispScorer[K]) Score(tree *rrcf.Tree[K], sampleIndex K) (float64, error) {
+	return tree.CoDisp(sampleIndex)
+}
+
+func (coDispScorer[K]) ScoreTree(tree *rrcf.Tree[K]) map[K]float64 {
+	return tree.CoDispAll()
+}
+
+func (coDispScorer[K]) ScorePoint(tree *rrcf.Tree[K], point []float64) (float64, error) {
+	return tree.ExpectedCoDisp(point, 0)
+}
+
+type isolationScorer[K comparable] struct{}
+
+func (isolationScorer[K]) Score(tree *rrcf.Tree[K], sampleIndex K) (float64, error) {
+	return tree.IsolationScore(sampleIndex)
+}
+
+type massScorer[K comparable] struct{}
+
+func (massScorer[K]) Score(tree *rrcf.Tree[K], sampleIndex K) (float64, error) {
+	return tree.MassScore(sampleIndex)
+}
+
+type bestDispScorer[K comparable] struct{}
+
+func (bestDispScorer[K]) Score(tree *rrcf.Tree[K], sampleIndex K) (float64, error) {
+	disp, err := tree.BestDisp(sampleIndex)
+	return float64(disp), err
+}
+
+type minMassCoDispScorer[K comparable] struct {
+	minMass int
+}
+
+func (s minMassCoDispScorer[K]) Score(tree *rrcf.Tree[K], sampleIndex K) (float64, error) {
+	return tree.MinMassCoDisp(sampleIndex, s.minMass)
+}
@@ -0,0 +1,118 @@
+package forest
+
+import (
+	"fmt"
+
+	"github.com/andysgithub/go-rrcf/rrcf"
+)
+
+// ShingleMode sets how the points of a stream are combined into shingles
+type ShingleMode int
+
+const (
+	// ShingleValues shingles single-valued streams, and passes multi-dimensional
+	// points to the trees unchanged
+	ShingleValues ShingleMode = iota
+	// ShingleSliding concatenates the last ShingleSize points of any dimension,
+	// oldest first
+	ShingleSliding
+	// ShingleRotating concatenates the last ShingleSize points of any dimension,
+	// with each new point written over the oldest in place, as in the internal
+	// shingling of AWS RCF
+	ShingleRotating
+)
+
+// SetShingling sets how the points of a stream are combined into shingles
+// Changing the mode restarts the shingle
+func (f *Forest[K]) SetShingling(mode ShingleMode) error {
+	if mode < ShingleValues || mode > ShingleRotating {
+		return fmt.Errorf("%w: shingle mode (%d)", ErrInvalidParameter, mode)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if mode != f.shingleMode {
+		f.shingleMode = mode
+		f.resetShingle()
+	}
+	return nil
+}
+
+// ResetShingle discards the points held in the shingle, so the next shingle is
+// formed from new points only, as after a break in the stream
+func (f *Forest[K]) ResetShingle() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.resetShingle()
+}
+
+func (f *Forest[K]) resetShingle() {
+	f.Shingle = nil
+	f.shingleCount = 0
+	f.shingleDims = 0
+	f.history = nil
+}
+
+// ShinglePoints returns the points held in the shingle, oldest first
+// The shingle is full once it holds ShingleSize points
+func (f *Forest[K]) ShinglePoints() [][]float64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	dims := f.shingleDims
+	if dims == 0 {
+		dims = 1
+	}
+	blocks := len(f.Shingle) / dims
+	oldest := 0
+	if f.shingleMode == ShingleRotating && blocks == f.ShingleSize {
+		oldest = f.shingleCount % f.ShingleSize
+	}
+	points := make([][]float64, blocks)
+	for i := range points {
+		block := (oldest + i) % blocks
+		points[i] = append([]float64(nil), f.Shingle[block*dims:(block+1)*dims]...)
+	}
+	return points
+}
+
+// shingled reports whether a point is combined into shingles before insertion
+func (f *Forest[K]) shingled(point []float64) bool {
+	return f.ShingleSize > 0 && (len(point) == 1 || f.shingleMode != ShingleValues)
+}
+
+// nextShingle returns a copy of the shingle with the point added, and the block of
+// the shingle holding the point
+// The shingle is full once its length is ShingleSize times the dimension of the points
+func (f *Forest[K]) nextShingle(point []float64) ([]float64, int, error) {
+	if f.shingleDims > 0 && len(point) != f.shingleDims {
+		return nil, 0, fmt.Errorf("%w: point (%d), shingle (%d)", rrcf.ErrDimensionMismatch, len(point), f.shingleDims)
+	}
+	shingle, block := shiftShingle(f.Shingle, f.shingleCount, point, f.ShingleSize, f.shingleMode)
+	return shingle, block, nil
+}
+
+// setShingle keeps the shingle formed by adding a point
+func (f *Forest[K]) setShingle(shingle []float64, dims int) {
+	f.Shingle = shingle
+	f.shingleCount++
+	f.shingleDims = dims
+}
+
+// shiftShingle returns a copy of a shingle holding count points with the point added,
+// and the block of the shingle holding the point
+// Until the shingle is full, points are appended in either mode
+func shiftShingle(shingle []float64, count int, point []float64, size int, mode ShingleMode) ([]float64, int) {
+	dims := len(point)
+	if len(shingle) < size*dims {
+		return append(append([]float64(nil), shingle...), point...), len(shingle) / dims
+	}
+	if mode == ShingleRotating {
+		block := count % size
+		next := append([]float64(nil), shingle...)
+		copy(next[block*dims:], point)
+		return next, block
+	}
+	return append(append([]float64(nil), shingle[dims:]...), point...), size - 1
+}
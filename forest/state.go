@@ -0,0 +1,417 @@
+package forest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/andysgithub/go-rrcf/random"
+	"github.com/andysgithub/go-rrcf/rrcf"
+)
+
+// ForestStateVersion is the version of the forest state format written by State
+const ForestStateVersion = 1
+
+// ForestState is the serializable state of a Forest with labels of type K
+//
+// Besides the trees, it records everything a forest needs to continue streaming
+// exactly as the original would: the shingle, the random number generators of the
+// forest and its samplers, the sampling policy, scorer, aggregation and normalization
+// with its recent scores, any thresholder and episode tracker, and any time window
+// with the event times of the points held. Samplers and scorers are recorded by kind,
+// so the state of a forest with a custom Sampler or Scorer cannot be taken.
+//
+// Encoding the state as json requires labels that are integers, strings or
+// implement encoding.TextMarshaler, as for the trees.
+type ForestState[K comparable] struct {
+	Version     int                   `json:"version"`      // Version of the state format
+	NumTrees    int                   `json:"num_trees"`    // Number of trees in the forest
+	TreeSize    int                   `json:"tree_size"`    // Number of leaves retained in each tree when streaming
+	DataPoints  int                   `json:"data_points"`  // Number of points inserted into the trees
+	ShingleSize int                   `json:"shingle_size"` // Number of points in each shingle
+	Workers     int                   `json:"workers"`      // Maximum goroutines for per-tree work
+	Rng         []byte                `json:"rng"`          // State of the random number generator of the forest
+	Trees       []rrcf.TreeStateOf[K] `json:"trees"`        // State of each tree
+
+	Sampling string            `json:"sampling"`        // Sampling policy: "fifo", "reservoir" or "decayed"
+	Decay    float64           `json:"decay,omitempty"` // Decay of the decayed sampling policy
+	Samplers []SamplerState[K] `json:"samplers"`        // State of the Sampler of each tree created so far
+
+	Scorer        string            `json:"scorer"`                   // Scorer: "codisp", "isolation", "mass", "bestdisp" or "minmass"
+	MinMass       int               `json:"min_mass,omitempty"`       // Minimum mass of the minmass scorer
+	Aggregation   AggregationConfig `json:"aggregation"`              // How the scores of the trees are combined
+	Normalization Normalization     `json:"normalization"`            // How scores are normalized
+	History       int               `json:"history,omitempty"`        // Number of recent scores held for normalization
+	Scores        []float64         `json:"scores,omitempty"`         // Recent raw scores, oldest first
+	Thresholder   *ThresholderState `json:"thresholder,omitempty"`    // State of the thresholder, if set
+	Episodes      *EpisodeState     `json:"episodes,omitempty"`       // State of the episode tracker, if set
+	ShingleMode   ShingleMode       `json:"shingle_mode"`             // How points are combined into shingles
+	Shingle       []float64         `json:"shingle"`                  // Most recent points of the stream
+	ShingleCount  int               `json:"shingle_count"`            // Number of points added to the shingle
+	ShingleDims   int               `json:"shingle_dims"`             // Dimension of the points in the shingle
+	Updates       int               `json:"updates"`                  // Number of points assessed
+	Window        *TimeWindow       `json:"window,omitempty"`         // Window of event times held by the trees, if set
+	ValueTimes    []time.Time       `json:"value_times,omitempty"`    // Event times of the recent values of a single-valued stream
+	Values        []float64         `json:"values,omitempty"`         // Recent values of a single-valued stream, in event time order
+	Times         map[K]time.Time   `json:"times,omitempty"`          // Current event time of each point held by the trees
+	TimelineTimes []time.Time       `json:"timeline_times,omitempty"` // Event times in the timeline heap, in heap order
+	Timeline      []K               `json:"timeline,omitempty"`       // Labels in the timeline heap, in heap order
+	Untimed       []K               `json:"untimed,omitempty"`        // Points held without event times
+	Latest        time.Time         `json:"latest"`                   // Latest event time received
+}
+
+// SamplerState is the serializable state of a built-in Sampler
+// Members lists the points kept by a reservoir sampler in member order, or by a
+// decayed sampler in heap order with their Keys.
+type SamplerState[K comparable] struct {
+	Kind    string    `json:"kind"`              // Kind of sampler: "fifo", "reservoir" or "decayed"
+	Rng     []byte    `json:"rng,omitempty"`     // State of the random number generator of the sampler
+	Decay   float64   `json:"decay,omitempty"`   // Decay of a decayed sampler
+	Seen    int       `json:"seen,omitempty"`    // Number of points offered to the tree
+	Members []K       `json:"members,omitempty"` // Points kept in the tree
+	Keys    []float64 `json:"keys,omitempty"`    // Random keys of the points kept by a decayed sampler
+	Next    float64   `json:"next,omitempty"`    // Key drawn for the point last sampled by a decayed sampler
+}
+
+// ThresholderState is the serializable state of a Thresholder
+type ThresholderState struct {
+	Config ThresholdConfig         `json:"config"` // Configuration of the thresholder
+	Seen   int                     `json:"seen"`   // Number of scores seen
+	Blocks [2]*ThresholdBlockState `json:"blocks"` // Estimates from the recent scores, nil for a block not started
+}
+
+// ThresholdBlockState is the serializable state of the estimates from a run of scores
+type ThresholdBlockState struct {
+	Count     int        `json:"count"`     // Number of scores in the run
+	Mean      float64    `json:"mean"`      // Mean of the scores
+	M2        float64    `json:"m2"`        // Sum of squared differences from the mean
+	Heights   [5]float64 `json:"heights"`   // Heights of the P² quantile markers
+	Positions [5]float64 `json:"positions"` // Positions of the P² quantile markers
+	Desired   [5]float64 `json:"desired"`   // Desired positions of the P² quantile markers
+}
+
+// EpisodeState is the serializable state of an EpisodeTracker
+type EpisodeState struct {
+	Config   EpisodeConfig `json:"config"`            // Configuration of the tracker
+	Current  *Episode      `json:"current,omitempty"` // Episode in progress, if any
+	Alerted  bool          `json:"alerted"`           // Whether the episode in progress has been alerted
+	Suppress bool          `json:"suppress"`          // Whether the episode in progress started within the cooldown
+	LastEnd  int           `json:"last_end"`          // Sample index at the end of the last alerted episode
+	HasEnded bool          `json:"has_ended"`         // Whether an alerted episode has ended
+}
+
+// State returns the complete state of the forest in serializable form
+func (f *Forest[K]) State() (ForestState[K], error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	state := ForestState[K]{
+		Version:       ForestStateVersion,
+		NumTrees:      f.NumTrees,
+		TreeSize:      f.TreeSize,
+		DataPoints:    f.DataPoints,
+		ShingleSize:   f.ShingleSize,
+		Workers:       f.Workers,
+		Rng:           f.Rng.State(),
+		Aggregation:   f.aggregation,
+		Normalization: f.normalization,
+		ShingleMode:   f.shingleMode,
+		Shingle:       append([]float64(nil), f.Shingle...),
+		ShingleCount:  f.shingleCount,
+		ShingleDims:   f.shingleDims,
+		Updates:       f.updates,
+		Untimed:       append([]K(nil), f.untimed...),
+		Latest:        f.latest,
+	}
+	for treeIndex := range f.Trees {
+		state.Trees = append(state.Trees, f.Trees[treeIndex].State())
+	}
+
+	sampling := f.sampling
+	if sampling == nil {
+		sampling = FIFOSampling[K]()
+	}
+	policy, err := samplerState(sampling(0))
+	if err != nil {
+		return state, err
+	}
+	state.Sampling, state.Decay = policy.Kind, policy.Decay
+	for _, sampler := range f.Samplers {
+		samplerState, err := samplerState(sampler)
+		if err != nil {
+			return state, err
+		}
+		state.Samplers = append(state.Samplers, samplerState)
+	}
+
+	switch scorer := f.scorer.(type) {
+	case nil, coDispScorer[K]:
+		state.Scorer = "codisp"
+	case isolationScorer[K]:
+		state.Scorer = "isolation"
+	case massScorer[K]:
+		state.Scorer = "mass"
+	case bestDispScorer[K]:
+		state.Scorer = "bestdisp"
+	case minMassCoDispScorer[K]:
+		state.Scorer, state.MinMass = "minmass", scorer.minMass
+	default:
+		return state, fmt.Errorf("%w: scorer %T cannot be saved", ErrInvalidParameter, f.scorer)
+	}
+
+	if h := f.scoreHistory; h != nil {
+		state.History = cap(h.recent)
+		state.Scores = append(append([]float64{}, h.recent[h.next:]...), h.recent[:h.next]...)
+	}
+	if th := f.thresholder; th != nil {
+		state.Thresholder = &ThresholderState{Config: th.config, Seen: th.seen}
+		for i, block := range th.blocks {
+			if block != nil {
+				state.Thresholder.Blocks[i] = &ThresholdBlockState{
+					block.count, block.mean, block.m2,
+					block.quantile.heights, block.quantile.positions, block.quantile.desired,
+				}
+			}
+		}
+	}
+	if tr := f.episodes; tr != nil {
+		state.Episodes = &EpisodeState{Config: tr.config, Alerted: tr.alerted, Suppress: tr.suppress, LastEnd: tr.lastEnd, HasEnded: tr.hasEnded}
+		if episode, ok := tr.Current(); ok {
+			state.Episodes.Current = &episode
+		}
+	}
+	if f.window != nil {
+		window := *f.window
+		state.Window = &window
+	}
+
+	for _, value := range f.history {
+		state.ValueTimes = append(state.ValueTimes, value.time)
+		state.Values = append(state.Values, value.value)
+	}
+	if len(f.timed) > 0 {
+		state.Times = make(map[K]time.Time, len(f.timed))
+		for index, eventTime := range f.timed {
+			state.Times[index] = eventTime
+		}
+	}
+	for _, entry := range f.timeline {
+		state.TimelineTimes = append(state.TimelineTimes, entry.time)
+		state.Timeline = append(state.Timeline, entry.index)
+	}
+	return state, nil
+}
+
+// samplerState returns the state of a built-in Sampler
+func samplerState[K comparable](sampler Sampler[K]) (SamplerState[K], error) {
+	switch s := sampler.(type) {
+	case fifoSampler[K]:
+		return SamplerState[K]{Kind: "fifo"}, nil
+	case *reservoirSampler[K]:
+		return SamplerState[K]{Kind: "reservoir", Rng: s.rng.State(), Seen: s.seen, Members: append([]K(nil), s.members...)}, nil
+	case *decayedSampler[K]:
+		state := SamplerState[K]{Kind: "decayed", Rng: s.rng.State(), Decay: s.decay, Seen: s.seen, Next: s.next}
+		if s.members != nil {
+			for _, key := range s.members.keys {
+				state.Members = append(state.Members, key.index)
+				state.Keys = append(state.Keys, key.key)
+			}
+		}
+		return state, nil
+	}
+	return SamplerState[K]{}, fmt.Errorf("%w: sampler %T cannot be saved", ErrInvalidParameter, sampler)
+}
+
+// NewForestFromState rebuilds a forest from the state returned by State
+func NewForestFromState[K comparable](state ForestState[K]) (*Forest[K], error) {
+	if state.Version != ForestStateVersion {
+		return nil, fmt.Errorf("Unsupported forest state version: %d", state.Version)
+	}
+	f, err := newForest[K](state.NumTrees, state.TreeSize, state.ShingleSize, nil)
+	if err != nil {
+		return nil, err
+	}
+	if f.Rng, err = random.RestoreRandomState(state.Rng); err != nil {
+		return nil, fmt.Errorf("Invalid random number generator state: %w", err)
+	}
+	if len(state.Trees) > state.NumTrees || len(state.Samplers) > len(state.Trees) {
+		return nil, fmt.Errorf("%w: %d trees and %d samplers for %d trees", ErrInvalidParameter, len(state.Trees), len(state.Samplers), state.NumTrees)
+	}
+	f.DataPoints = state.DataPoints
+	f.Workers = state.Workers
+	for _, treeState := range state.Trees {
+		tree, err := rrcf.NewTreeFromState(treeState)
+		if err != nil {
+			return nil, err
+		}
+		f.Trees = append(f.Trees, tree)
+	}
+
+	switch state.Sampling {
+	case "fifo":
+		f.sampling = FIFOSampling[K]()
+	case "reservoir":
+		f.sampling = ReservoirSampling[K]()
+	case "decayed":
+		f.sampling = DecayedSampling[K](state.Decay)
+	default:
+		return nil, fmt.Errorf("%w: sampling policy %q", ErrInvalidParameter, state.Sampling)
+	}
+	for _, samplerState := range state.Samplers {
+		sampler, err := newSamplerFromState(samplerState)
+		if err != nil {
+			return nil, err
+		}
+		f.Samplers = append(f.Samplers, sampler)
+	}
+
+	switch state.Scorer {
+	case "codisp":
+	case "isolation":
+		f.scorer = IsolationScorer[K]()
+	case "mass":
+		f.scorer = MassScorer[K]()
+	case "bestdisp":
+		f.scorer = BestDispScorer[K]()
+	case "minmass":
+		f.scorer = MinMassCoDispScorer[K](state.MinMass)
+	default:
+		return nil, fmt.Errorf("%w: scorer %q", ErrInvalidParameter, state.Scorer)
+	}
+	if err := f.SetAggregation(state.Aggregation); err != nil {
+		return nil, err
+	}
+
+	if state.Normalization != NormalizeNone {
+		if err := f.SetNormalization(state.Normalization, state.History); err != nil {
+			return nil, err
+		}
+		for _, score := range state.Scores {
+			f.scoreHistory.add(score)
+		}
+	}
+	if th := state.Thresholder; th != nil {
+		if f.thresholder, err = NewThresholder(th.Config); err != nil {
+			return nil, err
+		}
+		f.thresholder.seen = th.Seen
+		for i, blockState := range th.Blocks {
+			f.thresholder.blocks[i] = nil
+			if blockState != nil {
+				block := f.thresholder.newBlock()
+				block.count, block.mean, block.m2 = blockState.Count, blockState.Mean, blockState.M2
+				block.quantile.count = blockState.Count
+				block.quantile.heights = blockState.Heights
+				block.quantile.positions = blockState.Positions
+				block.quantile.desired = blockState.Desired
+				f.thresholder.blocks[i] = block
+			}
+		}
+		if f.thresholder.blocks[0] == nil {
+			return nil, fmt.Errorf("%w: thresholder without estimates", ErrInvalidParameter)
+		}
+	}
+	if tr := state.Episodes; tr != nil {
+		if f.episodes, err = NewEpisodeTracker(tr.Config); err != nil {
+			return nil, err
+		}
+		if tr.Current != nil {
+			episode := *tr.Current
+			f.episodes.current = &episode
+		}
+		f.episodes.alerted, f.episodes.suppress = tr.Alerted, tr.Suppress
+		f.episodes.lastEnd, f.episodes.hasEnded = tr.LastEnd, tr.HasEnded
+	}
+
+	if err := f.SetShingling(state.ShingleMode); err != nil {
+		return nil, err
+	}
+	f.Shingle = append([]float64(nil), state.Shingle...)
+	f.shingleCount, f.shingleDims = state.ShingleCount, state.ShingleDims
+	f.updates = state.Updates
+
+	if state.Window != nil {
+		if err := f.SetTimeWindow(*state.Window); err != nil {
+			return nil, err
+		}
+	}
+	if len(state.ValueTimes) != len(state.Values) || len(state.TimelineTimes) != len(state.Timeline) {
+		return nil, fmt.Errorf("%w: event times do not match their values", ErrInvalidParameter)
+	}
+	for i, value := range state.Values {
+		f.history = append(f.history, timedValue{state.ValueTimes[i], value})
+	}
+	f.timed = make(map[K]time.Time, len(state.Times))
+	for index, eventTime := range state.Times {
+		f.timed[index] = eventTime
+	}
+	for i, index := range state.Timeline {
+		f.timeline = append(f.timeline, timedIndex[K]{state.TimelineTimes[i], index})
+	}
+	f.untimed = append([]K(nil), state.Untimed...)
+	f.latest = state.Latest
+	return f, nil
+}
+
+// newSamplerFromState rebuilds a built-in Sampler from its state
+func newSamplerFromState[K comparable](state SamplerState[K]) (Sampler[K], error) {
+	if state.Kind == "fifo" {
+		return fifoSampler[K]{}, nil
+	}
+	rng, err := random.RestoreRandomState(state.Rng)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid random number generator state: %w", err)
+	}
+	switch state.Kind {
+	case "reservoir":
+		s := &reservoirSampler[K]{rng: rng, seen: state.Seen, members: append([]K{}, state.Members...), positions: make(map[K]int, len(state.Members))}
+		for position, index := range state.Members {
+			s.positions[index] = position
+		}
+		return s, nil
+	case "decayed":
+		if len(state.Keys) != len(state.Members) {
+			return nil, fmt.Errorf("%w: %d keys for %d sampled points", ErrInvalidParameter, len(state.Keys), len(state.Members))
+		}
+		s := &decayedSampler[K]{rng: rng, decay: state.Decay, seen: state.Seen, next: state.Next}
+		s.members = &decayedKeys[K]{positions: make(map[K]int, len(state.Members))}
+		for position, index := range state.Members {
+			s.members.keys = append(s.members.keys, decayedKey[K]{state.Keys[position], index})
+			s.members.positions[index] = position
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("%w: sampler %q", ErrInvalidParameter, state.Kind)
+}
+
+// MarshalJSON encodes the complete state of the forest as json
+func (f *Forest[K]) MarshalJSON() ([]byte, error) {
+	state, err := f.State()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(state)
+}
+
+// SaveForest saves the state of a forest as json data to the specified file
+func SaveForest[K comparable](f *Forest[K], filename string) error {
+	forestJSON, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, forestJSON, 0644)
+}
+
+// LoadForest loads a forest from json data saved by SaveForest
+func LoadForest[K comparable](filename string) (*Forest[K], error) {
+	forestJSON, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var state ForestState[K]
+	if err := json.Unmarshal(forestJSON, &state); err != nil {
+		return nil, err
+	}
+	return NewForestFromState(state)
+}
@@ -0,0 +1,143 @@
+package forest
+
+import (
+	"fmt"
+	"math"
+)
+
+// ThresholdRule sets how a threshold is found from the recent scores
+type ThresholdRule int
+
+const (
+	// PercentileRule sets the threshold at a percentile of the recent scores
+	PercentileRule ThresholdRule = iota
+	// ZScoreRule sets the threshold a number of standard deviations above the mean
+	// of the recent scores
+	ZScoreRule
+)
+
+// ThresholdConfig configures a Thresholder
+type ThresholdConfig struct {
+	Rule       ThresholdRule // Rule for finding the threshold
+	Percentile float64       // Percentile of the recent scores, between 0 and 100, for PercentileRule
+	ZScore     float64       // Standard deviations above the mean of the recent scores, for ZScoreRule
+	WarmUp     int           // Number of scores seen before grading starts
+	Window     int           // Number of recent scores the threshold follows, or 0 for all scores
+}
+
+// Grade records the grading of a score against the threshold
+type Grade struct {
+	Threshold float64 // Threshold the score was graded against, or 0 during warm-up
+	Grade     float64 // Anomaly grade from 0 to 1, above 0 when the score exceeds the threshold
+}
+
+// Thresholder grades a stream of scores against a threshold found from the recent scores
+//
+// The threshold is estimated in constant memory, with the P² algorithm for
+// percentiles and running moments for z-scores. To follow the recent scores, two
+// blocks of estimates are started in turn, each Window scores apart, and each is
+// restarted after 2*Window scores. The older block, holding between Window and
+// 2*Window of the most recent scores, gives the threshold.
+type Thresholder struct {
+	config ThresholdConfig
+	seen   int                // Number of scores seen
+	blocks [2]*thresholdBlock // Estimates from the recent scores
+}
+
+// thresholdBlock records estimates from a run of consecutive scores
+type thresholdBlock struct {
+	count    int
+	mean     float64
+	m2       float64 // Sum of squared differences from the mean
+	quantile *p2Quantile
+}
+
+// NewThresholder creates a Thresholder with the given configuration
+func NewThresholder(config ThresholdConfig) (*Thresholder, error) {
+	switch {
+	case config.Rule == PercentileRule && (config.Percentile <= 0 || config.Percentile >= 100):
+		return nil, fmt.Errorf("%w: percentile (%v)", ErrInvalidParameter, config.Percentile)
+	case config.Rule == ZScoreRule && config.ZScore <= 0:
+		return nil, fmt.Errorf("%w: z-score (%v)", ErrInvalidParameter, config.ZScore)
+	case config.Rule != PercentileRule && config.Rule != ZScoreRule:
+		return nil, fmt.Errorf("%w: threshold rule (%d)", ErrInvalidParameter, config.Rule)
+	case config.WarmUp < 0 || config.Window < 0:
+		return nil, fmt.Errorf("%w: warm-up (%d), window (%d)", ErrInvalidParameter, config.WarmUp, config.Window)
+	}
+	th := &Thresholder{config: config}
+	th.blocks[0] = th.newBlock()
+	return th, nil
+}
+
+func (th *Thresholder) newBlock() *thresholdBlock {
+	return &thresholdBlock{quantile: newP2Quantile(th.config.Percentile / 100)}
+}
+
+// Grade grades a score against the threshold from the scores before it, then
+// includes the score in the estimates
+// The grade is 0 at or below the threshold, rising towards 1 as the score exceeds it
+func (th *Thresholder) Grade(score float64) Grade {
+	var grade Grade
+	if th.seen >= th.config.WarmUp && th.seen > 0 {
+		grade.Threshold = th.Threshold()
+		if score > grade.Threshold {
+			grade.Grade = (score - grade.Threshold) / score
+		}
+	}
+	th.add(score)
+	return grade
+}
+
+// Threshold returns the current threshold, or NaN if no scores have been seen
+func (th *Thresholder) Threshold() float64 {
+	block := th.blocks[0]
+	if th.blocks[1] != nil && th.blocks[1].count > block.count {
+		block = th.blocks[1]
+	}
+	if block.count == 0 {
+		return math.NaN()
+	}
+	if th.config.Rule == ZScoreRule {
+		return block.mean + th.config.ZScore*math.Sqrt(block.m2/float64(block.count))
+	}
+	return block.quantile.value()
+}
+
+// add includes a score in the estimates
+func (th *Thresholder) add(score float64) {
+	if window := th.config.Window; window > 0 {
+		if th.seen == window {
+			th.blocks[1] = th.newBlock()
+		}
+		for i, block := range th.blocks {
+			if block != nil && block.count >= 2*window {
+				th.blocks[i] = th.newBlock()
+			}
+		}
+	}
+	th.seen++
+	for _, block := range th.blocks {
+		if block == nil {
+			continue
+		}
+		block.count++
+		delta := score - block.mean
+		block.mean += delta / float64(block.count)
+		block.m2 += delta * (score - block.mean)
+		block.quantile.add(score)
+	}
+}
+
+// SetThreshold attaches a Thresholder to the forest, so that each result from
+// UpdateResult is graded against the threshold from the scores before it
+func (f *Forest[K]) SetThreshold(config ThresholdConfig) error {
+	th, err := NewThresholder(config)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.thresholder = th
+	return nil
+}
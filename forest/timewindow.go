@@ -0,0 +1,379 @@
+package forest
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/andysgithub/go-rrcf/rrcf"
+)
+
+// ErrLateEvent is returned for an event time too far behind the latest event time
+var ErrLateEvent = errors.New("Event time is outside the permitted lateness")
+
+// GapPolicy sets how a single-valued stream is shingled across a gap in time
+type GapPolicy int
+
+const (
+	// GapRestart starts a new shingle after a gap, so values either side are never joined
+	GapRestart GapPolicy = iota
+	// GapFill fills a gap with values imputed by the forest at each Interval,
+	// falling back to a restart if the forest cannot impute them
+	GapFill
+)
+
+// TimeWindow configures streaming by event time
+type TimeWindow struct {
+	Duration  time.Duration // Points older than this before the latest event time are evicted, or 0 for no limit
+	MaxPoints int           // Number of points held in each tree in place of TreeSize, or 0 for TreeSize
+	Lateness  time.Duration // How far an event may fall behind the latest event time and still be accepted
+	MaxGap    time.Duration // Longest gap between values joined in a shingle, or 0 for no limit
+	Interval  time.Duration // Expected spacing of the values of a single-valued stream
+	Gaps      GapPolicy     // Action on a gap in a single-valued stream longer than MaxGap
+}
+
+// timedValue records a value of a single-valued stream with its event time
+type timedValue struct {
+	time  time.Time
+	value float64
+}
+
+// timedIndex records the event time of a sample index held in the trees
+type timedIndex[K comparable] struct {
+	time  time.Time
+	index K
+}
+
+// timeline is a min-heap of sample indices ordered by event time
+type timeline[K comparable] []timedIndex[K]
+
+func (tl timeline[K]) Len() int            { return len(tl) }
+func (tl timeline[K]) Less(i, j int) bool  { return tl[i].time.Before(tl[j].time) }
+func (tl timeline[K]) Swap(i, j int)       { tl[i], tl[j] = tl[j], tl[i] }
+func (tl *timeline[K]) Push(x interface{}) { *tl = append(*tl, x.(timedIndex[K])) }
+func (tl *timeline[K]) Pop() interface{} {
+	old := *tl
+	entry := old[len(old)-1]
+	*tl = old[:len(old)-1]
+	return entry
+}
+
+// SetTimeWindow sets the window of event times held by the trees for UpdateAt
+// At least one of the duration and maximum points must be set, and an interval
+// is needed to fill gaps. The trees hold no more points than set by MaxPoints,
+// or TreeSize if not set, however long the duration.
+func (f *Forest[K]) SetTimeWindow(window TimeWindow) error {
+	if window.Duration < 0 || window.MaxPoints < 0 || window.Lateness < 0 || window.MaxGap < 0 || window.Interval < 0 {
+		return fmt.Errorf("%w: negative time window", ErrInvalidParameter)
+	}
+	if window.Duration == 0 && window.MaxPoints == 0 {
+		return fmt.Errorf("%w: time window has no duration or maximum points", ErrInvalidParameter)
+	}
+	if window.Gaps == GapFill && window.Interval == 0 {
+		return fmt.Errorf("%w: gaps cannot be filled without an interval", ErrInvalidParameter)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.window = &window
+	return nil
+}
+
+// UpdateAt inserts a point with the given event time and returns its average score,
+// evicting points that have fallen out of the time window
+// SetTimeWindow must be called first
+func (f *Forest[K]) UpdateAt(sampleIndex K, eventTime time.Time, point []float64) (float64, error) {
+	result, err := f.UpdateResultAt(sampleIndex, eventTime, point)
+	return result.Score, err
+}
+
+// UpdateResultAt updates the forest as for UpdateAt, and returns the result as for UpdateResult
+//
+// Events may arrive out of order, up to the window's lateness behind the latest event
+// time, and ErrLateEvent is returned for events any later than that. The values of a
+// single-valued stream are shingled in event time order, so a late value is scored as
+// the shingle ending at its own time, and joins the shingle for the values after it.
+// A late value scores 0 if too few values precede it to fill a shingle.
+// A gap longer than the window's MaxGap between a value and the one before it either
+// restarts the shingle or is filled with imputed values, as set by the window.
+// Multi-dimensional points shingled by SetShingling are joined in arrival order,
+// and a gap longer than MaxGap before a point restarts the shingle.
+func (f *Forest[K]) UpdateResultAt(sampleIndex K, eventTime time.Time, point []float64) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.window == nil {
+		return Result{}, fmt.Errorf("%w: no time window set", ErrInvalidParameter)
+	}
+	if len(point) == 0 {
+		return Result{}, fmt.Errorf("%w: point (0)", rrcf.ErrDimensionMismatch)
+	}
+
+	latest := f.latest
+	if latest.IsZero() || eventTime.After(latest) {
+		latest = eventTime
+	}
+	if eventTime.Before(latest.Add(-f.window.Lateness)) ||
+		(f.window.Duration > 0 && eventTime.Before(latest.Add(-f.window.Duration))) {
+		return Result{}, fmt.Errorf("%w: %v behind %v", ErrLateEvent, latest.Sub(eventTime), latest)
+	}
+
+	var result Result
+	var err error
+	if len(point) == 1 && f.ShingleSize > 0 {
+		result, err = f.updateValueAt(sampleIndex, eventTime, latest, point[0])
+	} else if f.shingled(point) {
+		// Multi-dimensional points are shingled in arrival order, restarting after a gap
+		if f.window.MaxGap > 0 && !f.latest.IsZero() && eventTime.Sub(f.latest) > f.window.MaxGap {
+			f.resetShingle()
+		}
+		result, err = f.updateShingle(point, func(shingle []float64) (Result, error) {
+			return f.updatePointAt(sampleIndex, eventTime, latest, shingle)
+		})
+	} else {
+		if point, err = f.impute(point); err != nil {
+			return Result{}, err
+		}
+		result, err = f.updatePointAt(sampleIndex, eventTime, latest, point)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	f.latest = latest
+	f.assess(sampleIndex, &result)
+	return result, nil
+}
+
+// updateValueAt shingles a value of a single-valued stream in event time order
+// and inserts the shingle ending at the value
+func (f *Forest[K]) updateValueAt(sampleIndex K, eventTime time.Time, latest time.Time, value float64) (Result, error) {
+	history := f.timedHistory(eventTime)
+	position := sort.Search(len(history), func(i int) bool {
+		return history[i].time.After(eventTime)
+	})
+
+	// Handle a gap before a value arriving in order
+	if position == len(history) && position > 0 && f.window.MaxGap > 0 &&
+		eventTime.Sub(history[position-1].time) > f.window.MaxGap {
+		history = f.bridgeGap(history, eventTime)
+		position = len(history)
+	}
+	history = append(history[:position], append([]timedValue{{eventTime, value}}, history[position:]...)...)
+
+	if position+1 < f.ShingleSize {
+		if math.IsNaN(value) {
+			return Result{}, fmt.Errorf("%w: cannot impute before shingle is filled", ErrInsufficientData)
+		}
+		f.setHistory(history, latest)
+		return Result{Attribution: make([]float64, f.ShingleSize), Lags: make([]float64, f.ShingleSize)}, nil
+	}
+
+	shingle, err := f.impute(shingleValues(history[:position+1], f.ShingleSize))
+	if err != nil {
+		return Result{}, err
+	}
+	result, err := f.updatePointAt(sampleIndex, eventTime, latest, shingle)
+	if err != nil {
+		return Result{}, err
+	}
+	// Keep any imputed value in the history for later shingles
+	history[position].value = shingle[f.ShingleSize-1]
+	f.setHistory(history, latest)
+	result.Lags = lagsFromShingle(result.Attribution, f.ShingleSize, f.ShingleSize-1)
+	return result, nil
+}
+
+// timedHistory returns a copy of the recent values of a single-valued stream
+// A shingle without times, as primed by WarmStart, is taken to end one Interval
+// before the event time
+func (f *Forest[K]) timedHistory(eventTime time.Time) []timedValue {
+	if len(f.history) > 0 || len(f.Shingle) == 0 {
+		return append([]timedValue(nil), f.history...)
+	}
+	history := make([]timedValue, len(f.Shingle))
+	for i, value := range f.Shingle {
+		steps := time.Duration(len(f.Shingle) - i)
+		history[i] = timedValue{eventTime.Add(-steps * f.window.Interval), value}
+	}
+	return history
+}
+
+// bridgeGap restarts or fills the history before a value arriving after a long gap
+func (f *Forest[K]) bridgeGap(history []timedValue, eventTime time.Time) []timedValue {
+	if f.window.Gaps != GapFill || len(history) < f.ShingleSize {
+		return nil
+	}
+	last := history[len(history)-1].time
+	missing := int(eventTime.Sub(last)/f.window.Interval) - 1
+	if eventTime.Sub(last)%f.window.Interval != 0 {
+		missing++
+	}
+	// Values further back than a shingle would be filled only to be dropped
+	if missing > f.ShingleSize-1 {
+		missing = f.ShingleSize - 1
+	}
+
+	shingle := shingleValues(history, f.ShingleSize)
+	for step := 1; step <= missing; step++ {
+		shingle = append(shingle[1:], math.NaN())
+		imputed, err := f.impute(shingle)
+		if err != nil || math.IsNaN(imputed[f.ShingleSize-1]) {
+			return nil
+		}
+		shingle = imputed
+		fillTime := eventTime.Add(-time.Duration(missing+1-step) * f.window.Interval)
+		history = append(history, timedValue{fillTime, shingle[f.ShingleSize-1]})
+	}
+	return history
+}
+
+// setHistory keeps the values of a single-valued stream needed for later shingles,
+// and sets the shingle to the newest values
+func (f *Forest[K]) setHistory(history []timedValue, latest time.Time) {
+	// Keep the values a late event could still fall among, and those before them
+	// needed to fill its shingle
+	cutoff := latest.Add(-f.window.Lateness)
+	first := sort.Search(len(history), func(i int) bool {
+		return !history[i].time.Before(cutoff)
+	})
+	if first > len(history)-f.ShingleSize {
+		first = len(history) - f.ShingleSize
+	}
+	first -= f.ShingleSize - 1
+	if first < 0 {
+		first = 0
+	}
+	f.history = append([]timedValue(nil), history[first:]...)
+	f.Shingle = shingleValues(f.history, f.ShingleSize)
+	f.shingleCount = len(f.Shingle)
+	f.shingleDims = 1
+}
+
+// shingleValues returns the last values of the history, up to the shingle size
+func shingleValues(history []timedValue, shingleSize int) []float64 {
+	if len(history) > shingleSize {
+		history = history[len(history)-shingleSize:]
+	}
+	values := make([]float64, len(history))
+	for i, entry := range history {
+		values[i] = entry.value
+	}
+	return values
+}
+
+// updatePointAt checks the point against every tree, evicts the points that have
+// fallen out of the time window, then inserts the point and records its event time
+// A point that would be rejected by the trees evicts nothing.
+func (f *Forest[K]) updatePointAt(sampleIndex K, eventTime time.Time, latest time.Time, point []float64) (Result, error) {
+	if err := f.checkPoint(sampleIndex, point); err != nil {
+		return Result{}, err
+	}
+	if f.window.Duration > 0 {
+		f.timeUntimed(eventTime)
+		if err := f.evictBefore(latest.Add(-f.window.Duration)); err != nil {
+			return Result{}, err
+		}
+	}
+	result, err := f.updatePoint(sampleIndex, point)
+	if err != nil || f.window.Duration == 0 {
+		return result, err
+	}
+	if f.holds(sampleIndex) {
+		f.recordTime(sampleIndex, eventTime)
+	}
+	return result, nil
+}
+
+// recordTime records the event time of a point held by the trees
+// The timeline may also hold earlier entries for the index, which are skipped as
+// they no longer match its event time
+func (f *Forest[K]) recordTime(index K, eventTime time.Time) {
+	if f.timed == nil {
+		f.timed = make(map[K]time.Time)
+	}
+	f.timed[index] = eventTime
+	heap.Push(&f.timeline, timedIndex[K]{eventTime, index})
+
+	// Drop stale entries once they make up most of the timeline
+	if len(f.timeline) > 2*len(f.timed)+32 {
+		f.compactTimeline()
+	}
+}
+
+// timeUntimed records event times for the points held without them, as after
+// WarmStart, one Interval apart and ending one Interval before the event time
+func (f *Forest[K]) timeUntimed(eventTime time.Time) {
+	for i, index := range f.untimed {
+		if f.holds(index) {
+			steps := time.Duration(len(f.untimed) - i)
+			f.recordTime(index, eventTime.Add(-steps*f.window.Interval))
+		}
+	}
+	f.untimed = nil
+}
+
+// dropTimes drops the event times of points no longer held by any tree, as after
+// eviction by the samplers or ForgetPoint
+func (f *Forest[K]) dropTimes(indices []K) {
+	for _, index := range indices {
+		if _, ok := f.timed[index]; ok && !f.holds(index) {
+			delete(f.timed, index)
+		}
+	}
+}
+
+// holds reports whether any tree holds the index
+func (f *Forest[K]) holds(index K) bool {
+	for _, tree := range f.Trees {
+		if _, ok := tree.Leaves[index]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// evictBefore forgets the points with event times before the cutoff from every tree
+func (f *Forest[K]) evictBefore(cutoff time.Time) error {
+	for len(f.timeline) > 0 && f.timeline[0].time.Before(cutoff) {
+		entry := heap.Pop(&f.timeline).(timedIndex[K])
+		// Skip the entries of points since evicted, or inserted again at a later time
+		if eventTime, ok := f.timed[entry.index]; !ok || !eventTime.Equal(entry.time) {
+			continue
+		}
+		delete(f.timed, entry.index)
+		for treeIndex := range f.Trees {
+			_, err := f.Trees[treeIndex].ForgetPoint(entry.index)
+			if err == nil {
+				f.forgetSampled(treeIndex, []K{entry.index})
+			} else if !errors.Is(err, rrcf.ErrNoSuchLeaf) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compactTimeline removes the entries that no longer match the event time of a
+// point held by the trees
+func (f *Forest[K]) compactTimeline() {
+	entries := f.timeline[:0]
+	for _, entry := range f.timeline {
+		if eventTime, ok := f.timed[entry.index]; ok && eventTime.Equal(entry.time) {
+			entries = append(entries, entry)
+		}
+	}
+	f.timeline = entries
+	heap.Init(&f.timeline)
+}
+
+// treeSize returns the number of points kept in each tree when streaming, set by
+// the MaxPoints of a time window in place of TreeSize
+func (f *Forest[K]) treeSize() int {
+	if f.window == nil || f.window.MaxPoints == 0 {
+		return f.TreeSize
+	}
+	return f.window.MaxPoints
+}
@@ -0,0 +1,162 @@
+package forest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/andysgithub/go-rrcf/array"
+	"github.com/andysgithub/go-rrcf/rrcf"
+)
+
+// NewForestFromHistory creates a streaming forest primed with historical data, as
+// if the history had been passed to Update with sample indices from startIndex
+// Streaming continues with Update from sample index startIndex+len(history)
+func NewForestFromHistory(numTrees int, treeSize int, history [][]float64, shingleSize int, startIndex int, randomState interface{}) (*Forest[int], error) {
+	forest, err := NewForest(numTrees, treeSize, nil, shingleSize, randomState)
+	if err != nil {
+		return nil, err
+	}
+	labels := make([]int, len(history))
+	for i := range labels {
+		labels[i] = startIndex + i
+	}
+	if err := forest.WarmStart(history, labels); err != nil {
+		return nil, err
+	}
+	return forest, nil
+}
+
+// WarmStart replaces the trees of the forest with trees built in batch from the tail
+// of the historical data, leaving the forest as if each point of the history had been
+// passed to Update with its label
+//
+// History is shingled as by Update, and the shingle is primed with the
+// last values. Each tree is built from the last TreeSize+1 points, as kept by FIFO
+// sampling, in time order, so that streaming evicts them oldest first.
+// If the forest is then streamed with UpdateAt, the points are taken to be one
+// Interval apart, ending one Interval before the first event time, as for the shingle.
+// WarmStartAt records their event times instead.
+func (f *Forest[K]) WarmStart(history [][]float64, labels []K) error {
+	return f.warmStart(history, labels, nil)
+}
+
+// WarmStartAt replaces the trees of the forest as for WarmStart, recording the event
+// time of each point of the history, so that the points leave the time window as
+// they would have if passed to UpdateAt
+// The times must be in order, and points already outside the time window are dropped.
+func (f *Forest[K]) WarmStartAt(history [][]float64, labels []K, times []time.Time) error {
+	if len(times) != len(history) {
+		return fmt.Errorf("%w: times (%d), history (%d)", ErrInvalidParameter, len(times), len(history))
+	}
+	for i := 1; i < len(times); i++ {
+		if times[i].Before(times[i-1]) {
+			return fmt.Errorf("%w: history times out of order at %d", ErrInvalidParameter, i)
+		}
+	}
+	return f.warmStart(history, labels, times)
+}
+
+func (f *Forest[K]) warmStart(history [][]float64, labels []K, times []time.Time) error {
+	if len(labels) != len(history) {
+		return fmt.Errorf("%w: labels (%d), history (%d)", ErrInvalidParameter, len(labels), len(history))
+	}
+	for _, point := range history {
+		if len(point) == 0 || len(point) != len(history[0]) {
+			return fmt.Errorf("%w: point (%d), history (%d)", rrcf.ErrDimensionMismatch, len(point), len(history[0]))
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Form the points and labels that Update would insert, with the position of each
+	// point in the history
+	var points [][]float64
+	var pointLabels []K
+	var positions []int
+	var shingle []float64
+	shingled := len(history) > 0 && f.shingled(history[0])
+	for i, point := range history {
+		if !shingled {
+			points = append(points, point)
+			pointLabels = append(pointLabels, labels[i])
+			positions = append(positions, i)
+			continue
+		}
+		shingle, _ = shiftShingle(shingle, i, point, f.ShingleSize, f.shingleMode)
+		if len(shingle) == f.ShingleSize*len(point) {
+			points = append(points, shingle)
+			pointLabels = append(pointLabels, labels[i])
+			positions = append(positions, i)
+		}
+	}
+
+	// Keep the tail that streaming would leave in each tree and, if timed, in the
+	// time window
+	first := 0
+	if len(points) > f.treeSize()+1 {
+		first = len(points) - f.treeSize() - 1
+	}
+	if times != nil && f.window != nil && f.window.Duration > 0 {
+		cutoff := times[len(times)-1].Add(-f.window.Duration)
+		for first < len(points) && times[positions[first]].Before(cutoff) {
+			first++
+		}
+	}
+	points, pointLabels, positions = points[first:], pointLabels[first:], positions[first:]
+
+	seeds := make([]int64, f.NumTrees)
+	for treeIndex := range seeds {
+		seeds[treeIndex] = f.Rng.Int63()
+	}
+	trees := make([]rrcf.Tree[K], f.NumTrees)
+	err := f.forEachTree(f.NumTrees, func(treeIndex int) error {
+		var err error
+		if len(points) == 0 {
+			trees[treeIndex], err = rrcf.NewTree[K](nil, nil, 0, seeds[treeIndex])
+		} else {
+			// Each tree rounds its own copy of the points
+			trees[treeIndex], err = rrcf.NewTree(array.DuplicateFloat(points), append([]K(nil), pointLabels...), 9, seeds[treeIndex])
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	f.Trees = trees
+	f.resetShingle()
+	if shingled {
+		f.Shingle = shingle
+		f.shingleCount = len(history)
+		f.shingleDims = len(history[0])
+	}
+	f.DataPoints = f.NumTrees * len(points)
+	// Samplers start afresh from the points in the new trees
+	f.Samplers = nil
+
+	// Record the event times of the points, or leave them to be timed by the first
+	// update by event time
+	f.timeline = nil
+	f.timed = nil
+	f.untimed = nil
+	f.latest = time.Time{}
+	if times == nil {
+		f.untimed = pointLabels
+		return nil
+	}
+	for i, index := range pointLabels {
+		f.recordTime(index, times[positions[i]])
+	}
+	if len(times) > 0 {
+		f.latest = times[len(times)-1]
+		if f.window != nil && len(history[0]) == 1 && f.ShingleSize > 0 {
+			timed := make([]timedValue, len(history))
+			for i, point := range history {
+				timed[i] = timedValue{times[i], point[0]}
+			}
+			f.setHistory(timed, f.latest)
+		}
+	}
+	return nil
+}
@@ -2,192 +2,333 @@ package main
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
-	"sort"
+	"sync"
 	"time"
 
-	"github.com/andysgithub/go-rrcf/array"
-	"github.com/andysgithub/go-rrcf/random"
-	"github.com/andysgithub/go-rrcf/rrcf"
+	"github.com/andysgithub/go-rrcf/forest"
 )
 
-// UserMap is a map of token/user pairs
-var UserMap map[string]*User
+// ErrUnknownForest is returned when no forest is recorded for a token
+var ErrUnknownForest = errors.New("Unknown forest token")
 
-// User struct records the RRCF details for one user
-type User struct {
-	Forest      []rrcf.RCTree
-	NumTrees    int
-	TreeSize    int
-	DataPoints  int
-	ShingleSize int
-	Shingle     []float64
-}
+// UserMap is a map of token/forest pairs
+// It provides a token-based wrapper around the forest package for use as a web service
+var UserMap map[string]*forest.Forest[int]
+
+// userMapMutex guards UserMap, so that forests can be used from multiple goroutines
+var userMapMutex sync.RWMutex
 
 func main() {
 }
 
+// GetForest returns the forest recorded for a token
+func GetForest(token string) (*forest.Forest[int], error) {
+	userMapMutex.RLock()
+	defer userMapMutex.RUnlock()
+
+	f, ok := UserMap[token]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownForest, token)
+	}
+	return f, nil
+}
+
 // InitForest initialises a forest from the given source data
 // Returns a token to reference the forest for use in subsequent calls
-func InitForest(numTrees int, treeSize int, data [][]float64, shingleSize int) string {
+func InitForest(numTrees int, treeSize int, data [][]float64, shingleSize int) (string, error) {
+	f, err := forest.NewForest(numTrees, treeSize, data, shingleSize, nil)
+	if err != nil {
+		return "", err
+	}
+	return addForest(f)
+}
+
+// addForest records a forest in the user map, returning its token
+func addForest(f *forest.Forest[int]) (string, error) {
+	userMapMutex.Lock()
+	defer userMapMutex.Unlock()
+
 	if UserMap == nil {
-		UserMap = make(map[string]*User)
+		UserMap = make(map[string]*forest.Forest[int])
 	}
 
 	// Generate a key token
 	b := make([]byte, 4)
-	rand.Read(b)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
 	token := fmt.Sprintf("%x", b)
 
-	dataPoints := 0
-	if data != nil {
-		dataPoints = len(data)
+	// Add key token to user map
+	UserMap[token] = f
+
+	// Return the token
+	return token, nil
+}
+
+// InitForestFromHistory initialises a streaming forest primed with historical data
+// Streaming continues with UpdateForest from sample index len(history)
+// Returns a token to reference the forest for use in subsequent calls
+func InitForestFromHistory(numTrees int, treeSize int, history [][]float64, shingleSize int) (string, error) {
+	f, err := forest.NewForestFromHistory(numTrees, treeSize, history, shingleSize, 0, nil)
+	if err != nil {
+		return "", err
 	}
+	return addForest(f)
+}
 
-	// Add key token to user map
-	UserMap[token] = &User{
-		NumTrees:    numTrees,
-		TreeSize:    treeSize,
-		DataPoints:  dataPoints,
-		ShingleSize: shingleSize,
-	}
-
-	if dataPoints == 0 {
-		NewEmptyForest(token)
-	} else {
-		sampleSizeRange := []int{int(dataPoints / treeSize), treeSize}
-		rnd := random.NewRandomState(time.Now().UTC().UnixNano())
-
-		for i := 0; GetTotalTrees(token) < numTrees; i++ {
-			// Select random subsets of points uniformly
-			rows := sampleSizeRange[0]
-			cols := sampleSizeRange[1]
-			ixs := rnd.Array(dataPoints, rows, cols)
-			for _, ix := range ixs[0 : rows-1] {
-				// Produce a new array as sampled rows from source data
-				sampledX := array.Sample(data, ix)
-				NewRCTree(token, sampledX, ix, 9, nil)
-			}
-		}
+// SaveForestState saves the complete state of a forest as json data to the specified file
+func SaveForestState(token string, filename string) error {
+	f, err := GetForest(token)
+	if err != nil {
+		return err
 	}
+	return forest.SaveForest(f, filename)
+}
 
-	// Return the token
-	return token
+// LoadForestState restores a forest saved by SaveForestState
+// Returns a token to reference the forest for use in subsequent calls
+func LoadForestState(filename string) (string, error) {
+	f, err := forest.LoadForest[int](filename)
+	if err != nil {
+		return "", err
+	}
+	return addForest(f)
 }
 
-// UpdateForest maintains a shingle internally by retaining previous data points
-func UpdateForest(token string, sampleIndex int, point []float64) float64 {
-	data := point
+// SaveForestSnapshot saves the complete state of a forest as a binary snapshot to the
+// specified file
+func SaveForestSnapshot(token string, filename string) error {
+	f, err := GetForest(token)
+	if err != nil {
+		return err
+	}
+	return forest.SaveForestBinary(f, filename)
+}
 
-	if len(point) == 1 {
-		// Only one data point, so use shingles
-		shingleSize := UserMap[token].ShingleSize
-		data = UserMap[token].Shingle
+// LoadForestSnapshot restores a forest saved by SaveForestSnapshot
+// Returns a token to reference the forest for use in subsequent calls
+func LoadForestSnapshot(filename string) (string, error) {
+	f, err := forest.LoadForestBinary(filename)
+	if err != nil {
+		return "", err
+	}
+	return addForest(f)
+}
 
-		data = append(data, point[0])
-		if len(data) > shingleSize {
-			data = data[1:]
-		}
-		UserMap[token].Shingle = data
+// SetForestSampling sets the policy for the points kept in each tree when streaming
+func SetForestSampling(token string, policy forest.SamplingPolicy[int]) error {
+	f, err := GetForest(token)
+	if err != nil {
+		return err
+	}
+	return f.SetSampling(policy)
+}
 
-		if len(data) < shingleSize {
-			return 0
-		}
+// SetForestScorer sets how the leaves of the trees in the forest are scored
+func SetForestScorer(token string, scorer forest.Scorer[int]) error {
+	f, err := GetForest(token)
+	if err != nil {
+		return err
 	}
+	return f.SetScorer(scorer)
+}
 
-	return UpdatePoint(token, sampleIndex, data)
+// SetForestAggregation sets how the scores of the trees in the forest are combined
+func SetForestAggregation(token string, config forest.AggregationConfig) error {
+	f, err := GetForest(token)
+	if err != nil {
+		return err
+	}
+	return f.SetAggregation(config)
 }
 
-// ScoreForest calculates the average score at each leaf across all trees
-func ScoreForest(token string) map[int]float64 {
-	// Create a map to store average scores at each leaf
-	avgScores := make(map[int]float64)
-	// Create a map to store the total occurences of each leaf index in the forest
-	leafTotals := make(map[int]float64)
+// SetForestThreshold attaches a streaming threshold to the forest, so that each
+// result from UpdateForestResult is graded against it
+func SetForestThreshold(token string, config forest.ThresholdConfig) error {
+	f, err := GetForest(token)
+	if err != nil {
+		return err
+	}
+	return f.SetThreshold(config)
+}
 
-	for _, tree := range UserMap[token].Forest {
-		keys := []int{}
-		for k := range tree.Leaves {
-			keys = append(keys, k)
-		}
-		sort.Ints(keys)
+// SetForestNormalization sets how the scores of the forest are normalized, against
+// the given number of recent scores or TreeSize if 0
+func SetForestNormalization(token string, normalization forest.Normalization, history int) error {
+	f, err := GetForest(token)
+	if err != nil {
+		return err
+	}
+	return f.SetNormalization(normalization, history)
+}
 
-		for _, key := range keys {
-			codisp, _ := tree.CoDisp(key)
-			avgScores[key] += codisp
-			leafTotals[key]++
-		}
+// SetForestEpisodes attaches episode tracking to the forest, so that each result
+// from UpdateForestResult carries the episode events caused by the point
+func SetForestEpisodes(token string, config forest.EpisodeConfig) error {
+	f, err := GetForest(token)
+	if err != nil {
+		return err
 	}
-	for key := range avgScores {
-		avgScores[key] /= leafTotals[key]
+	return f.SetEpisodes(config)
+}
+
+// SetForestShingling sets how the points of a stream are combined into shingles
+func SetForestShingling(token string, mode forest.ShingleMode) error {
+	f, err := GetForest(token)
+	if err != nil {
+		return err
 	}
+	return f.SetShingling(mode)
+}
 
-	return avgScores
+// ResetForestShingle discards the points held in the shingle of the forest
+func ResetForestShingle(token string) error {
+	f, err := GetForest(token)
+	if err != nil {
+		return err
+	}
+	f.ResetShingle()
+	return nil
 }
 
-// NewEmptyForest creates a forest of empty trees
-func NewEmptyForest(token string) {
-	numTrees := UserMap[token].NumTrees
-	for treeIndex := 0; treeIndex < numTrees; treeIndex++ {
-		NewRCTree(token, nil, nil, 0, nil)
+// GetForestShingle returns the points held in the shingle of the forest, oldest first
+func GetForestShingle(token string) ([][]float64, error) {
+	f, err := GetForest(token)
+	if err != nil {
+		return nil, err
 	}
+	return f.ShinglePoints(), nil
 }
 
-// NewRCTree creates a new tree and appends it to the forest
-func NewRCTree(token string, X [][]float64, indexLabels []int, precision int, randomState interface{}) {
-	tree := rrcf.NewRCTree(X, indexLabels, precision, randomState)
-	UserMap[token].Forest = append(UserMap[token].Forest, tree)
+// SetForestTimeWindow sets the window of event times held by the trees for UpdateForestAt
+func SetForestTimeWindow(token string, window forest.TimeWindow) error {
+	f, err := GetForest(token)
+	if err != nil {
+		return err
+	}
+	return f.SetTimeWindow(window)
 }
 
-// UpdatePoint inserts a new point into each tree and updates the score
-func UpdatePoint(token string, sampleIndex int, point []float64) float64 {
-	treeSize := UserMap[token].TreeSize
-	numTrees := UserMap[token].NumTrees
-	var avgScore float64
+// UpdateForestAt updates the forest with a point and its event time, evicting points
+// that have fallen out of the time window
+func UpdateForestAt(token string, sampleIndex int, eventTime time.Time, point []float64) (float64, error) {
+	f, err := GetForest(token)
+	if err != nil {
+		return 0, err
+	}
+	return f.UpdateAt(sampleIndex, eventTime, point)
+}
 
-	// For each tree in the forest
-	for treeIndex := 0; treeIndex < numTrees; treeIndex++ {
-		// If tree is above permitted size
-		if GetTotalLeaves(token, treeIndex) > treeSize {
-			// Drop the oldest point (FIFO)
-			ForgetPoint(token, treeIndex, sampleIndex-treeSize)
-		}
-		// Insert the new point into the tree
-		InsertPoint(token, treeIndex, point, sampleIndex, 0)
+// UpdateForest maintains a shingle internally by retaining previous data points
+func UpdateForest(token string, sampleIndex int, point []float64) (float64, error) {
+	f, err := GetForest(token)
+	if err != nil {
+		return 0, err
+	}
+	return f.Update(sampleIndex, point)
+}
 
-		// Compute codisp on the new point
-		newScore, _ := GetScore(token, treeIndex, sampleIndex)
-		// Take the average over all trees
-		avgScore += newScore / float64(numTrees)
+// UpdateForestResult updates the forest as for UpdateForest, and returns the score
+// together with the contribution of each dimension of the point
+// For a single-valued stream, the contribution of each lag in the shingle is included
+func UpdateForestResult(token string, sampleIndex int, point []float64) (forest.Result, error) {
+	f, err := GetForest(token)
+	if err != nil {
+		return forest.Result{}, err
+	}
+	return f.UpdateResult(sampleIndex, point)
+}
+
+// ScoreForest calculates the average score at each leaf across all trees
+func ScoreForest(token string) (map[int]float64, error) {
+	f, err := GetForest(token)
+	if err != nil {
+		return nil, err
 	}
-	return avgScore
+	return f.Score(), nil
+}
+
+// ScoreForestResults calculates the average score at each leaf across all trees,
+// together with the contribution of each dimension of the leaf
+func ScoreForestResults(token string) (map[int]forest.Result, error) {
+	f, err := GetForest(token)
+	if err != nil {
+		return nil, err
+	}
+	return f.ScoreResults(), nil
+}
+
+// ImputeForest returns the most plausible completion of a point whose missing values are NaN
+func ImputeForest(token string, point []float64) ([]float64, error) {
+	f, err := GetForest(token)
+	if err != nil {
+		return nil, err
+	}
+	return f.Impute(point)
+}
+
+// ForecastForest predicts the next values of a single-valued stream, up to the given horizon
+func ForecastForest(token string, horizon int) ([]forest.Forecast, error) {
+	f, err := GetForest(token)
+	if err != nil {
+		return nil, err
+	}
+	return f.Forecast(horizon)
+}
+
+// UpdatePoint inserts a new point into each tree and updates the score
+func UpdatePoint(token string, sampleIndex int, point []float64) (float64, error) {
+	f, err := GetForest(token)
+	if err != nil {
+		return 0, err
+	}
+	return f.UpdatePoint(sampleIndex, point)
 }
 
 // InsertPoint inserts a point into a tree, creating a new leaf
 func InsertPoint(token string, treeIndex int, point []float64, index int, tolerance float64) error {
-	_, err := UserMap[token].Forest[treeIndex].InsertPoint(point, index, 0)
-	if err == nil {
-		UserMap[token].DataPoints++
+	f, err := GetForest(token)
+	if err != nil {
+		return err
 	}
-	return err
+	return f.InsertPoint(treeIndex, point, index, tolerance)
 }
 
 // ForgetPoint deletes a leaf from the specified tree
-func ForgetPoint(token string, treeIndex int, index int) {
-	UserMap[token].Forest[treeIndex].ForgetPoint(index)
+func ForgetPoint(token string, treeIndex int, index int) error {
+	f, err := GetForest(token)
+	if err != nil {
+		return err
+	}
+	return f.ForgetPoint(treeIndex, index)
 }
 
 // GetTotalTrees returns the total number of trees in the forest
-func GetTotalTrees(token string) int {
-	return len(UserMap[token].Forest)
+func GetTotalTrees(token string) (int, error) {
+	f, err := GetForest(token)
+	if err != nil {
+		return 0, err
+	}
+	return f.TotalTrees(), nil
 }
 
 // GetTotalLeaves returns the number of leaves in the specified tree
-func GetTotalLeaves(token string, treeIndex int) int {
-	return len(UserMap[token].Forest[treeIndex].Leaves)
+func GetTotalLeaves(token string, treeIndex int) (int, error) {
+	f, err := GetForest(token)
+	if err != nil {
+		return 0, err
+	}
+	return f.TotalLeaves(treeIndex)
 }
 
 // GetScore returns the collusive displacement for a leaf in the specified tree
 func GetScore(token string, treeIndex int, sampleIndex int) (float64, error) {
-	return UserMap[token].Forest[treeIndex].CoDisp(sampleIndex)
+	f, err := GetForest(token)
+	if err != nil {
+		return 0, err
+	}
+	return f.GetScore(treeIndex, sampleIndex)
 }
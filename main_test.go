@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/andysgithub/go-rrcf/rrcf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnknownToken(t *testing.T) {
+	_, err := UpdateForest("unknown", 0, []float64{1})
+	assert.True(t, errors.Is(err, ErrUnknownForest), "Unknown token accepted: %v", err)
+	_, err = ScoreForest("unknown")
+	assert.True(t, errors.Is(err, ErrUnknownForest), "Unknown token accepted: %v", err)
+	err = ForgetPoint("unknown", 0, 0)
+	assert.True(t, errors.Is(err, ErrUnknownForest), "Unknown token accepted: %v", err)
+
+	token, err := InitForest(4, 16, nil, 0)
+	assert.NoError(t, err)
+	err = ForgetPoint(token, 0, 0)
+	assert.True(t, errors.Is(err, rrcf.ErrNoSuchLeaf), "Missing leaf accepted: %v", err)
+}
+
+func TestConcurrentTokens(t *testing.T) {
+	var wg sync.WaitGroup
+	for stream := 0; stream < 16; stream++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := InitForest(4, 32, nil, 3)
+			assert.NoError(t, err)
+			for sampleIndex := 0; sampleIndex < 100; sampleIndex++ {
+				_, err := UpdateForest(token, sampleIndex, []float64{math.Sin(float64(sampleIndex))})
+				assert.NoError(t, err)
+			}
+			_, err = ScoreForest(token)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+}
@@ -2,24 +2,73 @@ package random
 
 import (
 	"math/rand"
+	"time"
 
 	"github.com/andysgithub/go-rrcf/array"
 )
 
 // RandomState holds a reference to the random number generator for a specific instance
 type RandomState struct {
-	rnd *rand.Rand
+	rnd    *rand.Rand
+	source *rngSource
 }
 
 // NewRandomState sets the seed value for the random number generator
 func NewRandomState(seed int64) *RandomState {
+	source := newRNGSource(seed)
 	randomState := RandomState{
-		rand.New(rand.NewSource(seed)),
+		rand.New(source),
+		source,
 	}
 
 	return &randomState
 }
 
+// RestoreRandomState recreates a RandomState from the state returned by State
+func RestoreRandomState(state []byte) (*RandomState, error) {
+	source := &rngSource{}
+	if err := source.UnmarshalBinary(state); err != nil {
+		return nil, err
+	}
+	return &RandomState{rand.New(source), source}, nil
+}
+
+// State returns the state of the generator, from which RestoreRandomState can
+// reproduce it exactly
+// The state has the same size however many values have been drawn.
+func (rng *RandomState) State() []byte {
+	state, _ := rng.source.MarshalBinary()
+	return state
+}
+
+// NewRandomStateFrom returns a RandomState for a seed (int or int64), an existing
+// RandomState instance, or a random seed for any other value
+func NewRandomStateFrom(randomState interface{}) *RandomState {
+	switch randomState.(type) {
+	case int:
+		// Random number generation with provided seed
+		return NewRandomState(int64(randomState.(int)))
+	case int64:
+		return NewRandomState(randomState.(int64))
+	case *RandomState:
+		// The existing RandomState instance
+		return randomState.(*RandomState)
+	default:
+		// Random number generation with random seed
+		return NewRandomState(time.Now().UTC().UnixNano())
+	}
+}
+
+// Int63 returns a non-negative pseudo-random 63-bit integer, for seeding other instances
+func (rng *RandomState) Int63() int64 {
+	return rng.rnd.Int63()
+}
+
+// Intn returns a pseudo-random integer in the range [0, n)
+func (rng *RandomState) Intn(n int) int {
+	return rng.rnd.Intn(n)
+}
+
 // Normal1D generates a 1D array of normally distributed random floats
 func (rng *RandomState) Normal1D(rows int) []float64 {
 	newArray := make([]float64, rows)
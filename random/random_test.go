@@ -0,0 +1,37 @@
+package random
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRandomState(t *testing.T) {
+	// The generator gives the same values as the standard source for each seed
+	for _, seed := range []int64{0, 1, -5, 42, 1 << 40} {
+		standard := rand.New(rand.NewSource(seed))
+		rng := NewRandomState(seed)
+		for i := 0; i < 2000; i++ {
+			assert.Equal(t, standard.Int63(), rng.Int63(), "Seed %d differs at value %d", seed, i)
+		}
+		assert.Equal(t, standard.NormFloat64(), rng.Normal1D(1)[0])
+	}
+
+	// A restored generator continues with the same values, from a state of fixed size
+	rng := NewRandomState(7)
+	initial := rng.State()
+	rng.Normal2D(500, 3)
+	state := rng.State()
+	assert.Len(t, state, len(initial), "State size depends on values drawn")
+	restored, err := RestoreRandomState(state)
+	assert.NoError(t, err)
+	assert.Equal(t, rng.Normal2D(100, 3), restored.Normal2D(100, 3))
+
+	// Truncated or inconsistent states are rejected
+	_, err = RestoreRandomState(state[:len(state)-1])
+	assert.Error(t, err, "Truncated state accepted")
+	state[2]++
+	_, err = RestoreRandomState(state)
+	assert.Error(t, err, "Inconsistent positions accepted")
+}
@@ -0,0 +1,106 @@
+package random
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+)
+
+const (
+	rngLen   = 607
+	rngTap   = 273
+	rngMask  = 1<<63 - 1
+	rngState = 4 + 8*rngLen
+)
+
+// rngSource is the additive lagged Fibonacci generator of rand.NewSource, holding
+// its state where it can be recorded and restored
+// It gives exactly the same values as rand.NewSource for the same seed.
+type rngSource struct {
+	tap  int           // Position of the lagged value
+	feed int           // Position of the value to be replaced
+	vec  [rngLen]int64 // Most recent values of the sequence
+}
+
+// newRNGSource returns a generator giving the same values as rand.NewSource(seed)
+// The initial state of the standard source is recovered from its first rngLen values.
+// Each value is the sum of the values at the feed and tap positions, and replaces the
+// value at the feed position, which the tap reaches rngTap values later.
+func newRNGSource(seed int64) *rngSource {
+	standard := rand.NewSource(seed).(rand.Source64)
+	var values [rngLen + 1]int64
+	for k := 1; k <= rngLen; k++ {
+		values[k] = int64(standard.Uint64())
+	}
+
+	source := &rngSource{tap: 0, feed: rngLen - rngTap}
+	// The value drawn k-th reads the feed position rngLen-rngTap-k and the tap
+	// position -k, modulo rngLen
+	feed := func(k int) int {
+		return (2*rngLen - rngTap - k) % rngLen
+	}
+	for k := rngTap + 1; k <= rngLen; k++ {
+		// The tap reads the value drawn rngTap values earlier
+		source.vec[feed(k)] = values[k] - values[k-rngTap]
+	}
+	for k := 1; k <= rngTap; k++ {
+		// The tap reads an initial value, already recovered above
+		source.vec[feed(k)] = values[k] - source.vec[rngLen-k]
+	}
+	return source
+}
+
+// Seed resets the generator to the state of rand.NewSource(seed)
+func (source *rngSource) Seed(seed int64) {
+	*source = *newRNGSource(seed)
+}
+
+// Int63 returns a non-negative pseudo-random 63-bit integer
+func (source *rngSource) Int63() int64 {
+	return int64(source.Uint64() & rngMask)
+}
+
+// Uint64 returns a pseudo-random 64-bit integer
+func (source *rngSource) Uint64() uint64 {
+	source.tap--
+	if source.tap < 0 {
+		source.tap += rngLen
+	}
+	source.feed--
+	if source.feed < 0 {
+		source.feed += rngLen
+	}
+	x := source.vec[source.feed] + source.vec[source.tap]
+	source.vec[source.feed] = x
+	return uint64(x)
+}
+
+// MarshalBinary returns the state of the generator: the tap position as 2 little-endian
+// bytes, the feed position as 2 more, then the recent values as 8 bytes each
+func (source *rngSource) MarshalBinary() ([]byte, error) {
+	state := make([]byte, 0, rngState)
+	state = binary.LittleEndian.AppendUint16(state, uint16(source.tap))
+	state = binary.LittleEndian.AppendUint16(state, uint16(source.feed))
+	for _, value := range source.vec {
+		state = binary.LittleEndian.AppendUint64(state, uint64(value))
+	}
+	return state, nil
+}
+
+// UnmarshalBinary restores the state of the generator returned by MarshalBinary
+func (source *rngSource) UnmarshalBinary(state []byte) error {
+	if len(state) != rngState {
+		return errors.New("Random number generator state has invalid length")
+	}
+	tap := int(binary.LittleEndian.Uint16(state))
+	feed := int(binary.LittleEndian.Uint16(state[2:]))
+	if tap >= rngLen || feed != (tap+rngLen-rngTap)%rngLen {
+		return errors.New("Random number generator state has invalid positions")
+	}
+	source.tap = tap
+	source.feed = feed
+	for i := range source.vec {
+		source.vec[i] = int64(binary.LittleEndian.Uint64(state[4+8*i:]))
+	}
+	return nil
+}
@@ -0,0 +1,491 @@
+package rrcf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// BinaryVersion is the version of the binary snapshot format written by Encoder
+//
+// A snapshot starts with the magic bytes "RRCF" and the format version as a uvarint,
+// followed by one frame per tree and an end marker. Each frame is the uvarint length
+// of the tree payload, the payload itself and its CRC-32 (Castagnoli) checksum as
+// 4 little-endian bytes. The end marker is a frame length of zero.
+//
+// A tree payload holds the dimension, the state of the random number generator, index
+// labels, the nodes in pre-order, the leaves map and the insertion order. Integers are
+// varints, byte strings are preceded by their uvarint length and floats are raw 8-byte
+// little-endian values. Each node starts with a type byte and its count; branches
+// follow with the cut dimension, cut value and bounding box, and leaves with their
+// index, depth and point. Children are implied by the pre-order layout.
+const BinaryVersion = 1
+
+// maxFrameLength limits the size of a single tree payload when decoding
+const maxFrameLength = 1 << 30
+
+const (
+	binaryLeaf   = 0
+	binaryBranch = 1
+)
+
+var (
+	binaryMagic = []byte("RRCF")
+	crcTable    = crc32.MakeTable(crc32.Castagnoli)
+)
+
+var (
+	// ErrNotSnapshot is returned when data does not start with the snapshot header
+	ErrNotSnapshot = errors.New("Data is not an rrcf binary snapshot")
+	// ErrSnapshotVersion is returned for snapshots written in an unsupported format version
+	ErrSnapshotVersion = errors.New("Unsupported binary snapshot version")
+	// ErrChecksum is returned when a tree payload does not match its checksum
+	ErrChecksum = errors.New("Binary snapshot checksum mismatch")
+	// ErrCorruptSnapshot is returned when a tree payload cannot be decoded
+	ErrCorruptSnapshot = errors.New("Corrupt binary snapshot")
+)
+
+// Encoder writes trees to a binary snapshot
+type Encoder struct {
+	w             io.Writer
+	headerWritten bool
+}
+
+// NewEncoder returns an encoder writing a binary snapshot to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// writeHeader writes the magic bytes and format version if not already written
+func (enc *Encoder) writeHeader() error {
+	if enc.headerWritten {
+		return nil
+	}
+	header := binary.AppendUvarint(append([]byte{}, binaryMagic...), BinaryVersion)
+	if _, err := enc.w.Write(header); err != nil {
+		return err
+	}
+	enc.headerWritten = true
+	return nil
+}
+
+// Encode writes one tree to the snapshot as a checksummed frame
+func (enc *Encoder) Encode(tree RCTree) error {
+	return enc.EncodeState(tree.State())
+}
+
+// EncodeState writes the state of one tree, as returned by State, to the snapshot
+func (enc *Encoder) EncodeState(state TreeState) error {
+	if err := enc.writeHeader(); err != nil {
+		return err
+	}
+	payload := appendTreeState(nil, state)
+	frame := binary.AppendUvarint(nil, uint64(len(payload)))
+	frame = append(frame, payload...)
+	frame = binary.LittleEndian.AppendUint32(frame, crc32.Checksum(payload, crcTable))
+	_, err := enc.w.Write(frame)
+	return err
+}
+
+// Close writes the end marker of the snapshot
+// It does not close the underlying writer
+func (enc *Encoder) Close() error {
+	if err := enc.writeHeader(); err != nil {
+		return err
+	}
+	_, err := enc.w.Write(binary.AppendUvarint(nil, 0))
+	return err
+}
+
+// Decoder reads trees from a binary snapshot
+type Decoder struct {
+	r          *bufio.Reader
+	headerRead bool
+	done       bool
+}
+
+// NewDecoder returns a decoder reading a binary snapshot from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// readHeader checks the magic bytes and format version if not already read
+func (dec *Decoder) readHeader() error {
+	if dec.headerRead {
+		return nil
+	}
+	magic := make([]byte, len(binaryMagic))
+	if _, err := io.ReadFull(dec.r, magic); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrNotSnapshot
+		}
+		return err
+	}
+	if !bytes.Equal(magic, binaryMagic) {
+		return ErrNotSnapshot
+	}
+	version, err := binary.ReadUvarint(dec.r)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if version != BinaryVersion {
+		return fmt.Errorf("%w: %d", ErrSnapshotVersion, version)
+	}
+	dec.headerRead = true
+	return nil
+}
+
+// Decode reads the next tree from the snapshot
+// Returns io.EOF once the end marker has been read, or io.ErrUnexpectedEOF if the
+// snapshot is truncated
+func (dec *Decoder) Decode() (RCTree, error) {
+	var tree RCTree
+
+	state, err := dec.DecodeState()
+	if err != nil {
+		return tree, err
+	}
+	tree, err = NewRCTreeFromState(state)
+	if err != nil {
+		return tree, fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+	}
+	return tree, nil
+}
+
+// DecodeState reads the state of the next tree from the snapshot, without rebuilding
+// the tree
+// Returns io.EOF once the end marker has been read, or io.ErrUnexpectedEOF if the
+// snapshot is truncated
+func (dec *Decoder) DecodeState() (TreeState, error) {
+	var state TreeState
+
+	if dec.done {
+		return state, io.EOF
+	}
+	if err := dec.readHeader(); err != nil {
+		return state, err
+	}
+	length, err := binary.ReadUvarint(dec.r)
+	if err != nil {
+		return state, unexpectedEOF(err)
+	}
+	if length == 0 {
+		dec.done = true
+		return state, io.EOF
+	}
+	if length > maxFrameLength {
+		return state, fmt.Errorf("%w: frame length %d", ErrCorruptSnapshot, length)
+	}
+	frame := make([]byte, length+4)
+	if _, err := io.ReadFull(dec.r, frame); err != nil {
+		return state, unexpectedEOF(err)
+	}
+	payload := frame[:length]
+	if binary.LittleEndian.Uint32(frame[length:]) != crc32.Checksum(payload, crcTable) {
+		return state, ErrChecksum
+	}
+	return readTreeState(payload)
+}
+
+// unexpectedEOF reports the end of data in the middle of a snapshot as io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// WriteForest writes all trees of a forest to w as a binary snapshot
+func WriteForest(w io.Writer, forest []RCTree) error {
+	enc := NewEncoder(w)
+	for _, tree := range forest {
+		if err := enc.Encode(tree); err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
+
+// ReadForest reads all trees of a binary snapshot from r
+func ReadForest(r io.Reader) ([]RCTree, error) {
+	var forest []RCTree
+
+	dec := NewDecoder(r)
+	for {
+		tree, err := dec.Decode()
+		if err == io.EOF {
+			return forest, nil
+		}
+		if err != nil {
+			return forest, err
+		}
+		forest = append(forest, tree)
+	}
+}
+
+// SaveForestBinary saves a forest as a binary snapshot to the specified file
+func SaveForestBinary(forest []RCTree, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	if err := WriteForest(w, forest); err != nil {
+		file.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// LoadForestBinary loads a forest from a binary snapshot saved by SaveForestBinary
+func LoadForestBinary(filename string) ([]RCTree, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadForest(file)
+}
+
+// appendTreeState appends the binary payload for a tree state
+func appendTreeState(b []byte, state TreeState) []byte {
+	b = binary.AppendUvarint(b, uint64(state.Ndim))
+	b = binary.AppendUvarint(b, uint64(len(state.Rng)))
+	b = append(b, state.Rng...)
+
+	// Index labels are preceded by their count plus one, with zero for none
+	if state.IndexLabels == nil {
+		b = binary.AppendUvarint(b, 0)
+	} else {
+		b = binary.AppendUvarint(b, uint64(len(state.IndexLabels))+1)
+		for _, label := range state.IndexLabels {
+			b = binary.AppendVarint(b, int64(label))
+		}
+	}
+
+	b = binary.AppendUvarint(b, uint64(len(state.Nodes)))
+	for _, node := range state.Nodes {
+		if node.Type == "leaf" {
+			b = append(b, binaryLeaf)
+			b = binary.AppendUvarint(b, uint64(node.N))
+			b = binary.AppendVarint(b, int64(node.I))
+			b = binary.AppendUvarint(b, uint64(node.D))
+			b = appendFloats(b, node.X)
+		} else {
+			b = append(b, binaryBranch)
+			b = binary.AppendUvarint(b, uint64(node.N))
+			b = binary.AppendUvarint(b, uint64(node.Q))
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(node.P))
+			b = appendFloats(b, node.B[0])
+			b = appendFloats(b, node.B[1])
+		}
+	}
+
+	// Leaves are written in label order so that identical trees give identical snapshots
+	indices := make([]int, 0, len(state.Leaves))
+	for index := range state.Leaves {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+	b = binary.AppendUvarint(b, uint64(len(indices)))
+	for _, index := range indices {
+		b = binary.AppendVarint(b, int64(index))
+		b = binary.AppendUvarint(b, uint64(state.Leaves[index]))
+	}
+
+	b = binary.AppendUvarint(b, uint64(len(state.Order)))
+	for _, index := range state.Order {
+		b = binary.AppendVarint(b, int64(index))
+	}
+	return b
+}
+
+// appendFloats appends raw little-endian float64 values
+func appendFloats(b []byte, values []float64) []byte {
+	for _, value := range values {
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(value))
+	}
+	return b
+}
+
+// payloadReader decodes values from a tree payload, recording the first error
+type payloadReader struct {
+	data []byte
+	err  error
+}
+
+func (pr *payloadReader) uvarint() uint64 {
+	if pr.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(pr.data)
+	if n <= 0 {
+		pr.err = ErrCorruptSnapshot
+		return 0
+	}
+	pr.data = pr.data[n:]
+	return value
+}
+
+func (pr *payloadReader) varint() int64 {
+	if pr.err != nil {
+		return 0
+	}
+	value, n := binary.Varint(pr.data)
+	if n <= 0 {
+		pr.err = ErrCorruptSnapshot
+		return 0
+	}
+	pr.data = pr.data[n:]
+	return value
+}
+
+// length reads a count, checking that at least size bytes remain for each item
+func (pr *payloadReader) length(size int) int {
+	value := pr.uvarint()
+	if pr.err == nil && value > uint64(len(pr.data)/size) {
+		pr.err = ErrCorruptSnapshot
+		return 0
+	}
+	return int(value)
+}
+
+func (pr *payloadReader) byte() byte {
+	if pr.err != nil {
+		return 0
+	}
+	if len(pr.data) < 1 {
+		pr.err = ErrCorruptSnapshot
+		return 0
+	}
+	value := pr.data[0]
+	pr.data = pr.data[1:]
+	return value
+}
+
+func (pr *payloadReader) bytes(n int) []byte {
+	if pr.err != nil {
+		return nil
+	}
+	if len(pr.data) < n {
+		pr.err = ErrCorruptSnapshot
+		return nil
+	}
+	value := append([]byte{}, pr.data[:n]...)
+	pr.data = pr.data[n:]
+	return value
+}
+
+func (pr *payloadReader) floats(n int) []float64 {
+	if pr.err != nil {
+		return nil
+	}
+	if len(pr.data) < 8*n {
+		pr.err = ErrCorruptSnapshot
+		return nil
+	}
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = math.Float64frombits(binary.LittleEndian.Uint64(pr.data[8*i:]))
+	}
+	pr.data = pr.data[8*n:]
+	return values
+}
+
+// readTreeState decodes the binary payload for a tree state
+func readTreeState(payload []byte) (TreeState, error) {
+	pr := payloadReader{data: payload}
+	state := TreeState{
+		Version: StateVersion,
+		Root:    -1,
+		Leaves:  make(map[int]int),
+	}
+
+	state.Ndim = pr.length(1)
+	state.Rng = pr.bytes(pr.length(1))
+
+	if numLabels := pr.length(1); numLabels > 0 {
+		state.IndexLabels = make([]int, numLabels-1)
+		for i := range state.IndexLabels {
+			state.IndexLabels[i] = int(pr.varint())
+		}
+	}
+
+	numNodes := pr.length(2)
+	if pr.err == nil {
+		state.Nodes = make([]NodeState, numNodes)
+	}
+	// Positions of branches still waiting for their right child
+	var pending []int
+	for position := 0; position < numNodes && pr.err == nil; position++ {
+		// Link the node to the branch awaiting it, as the left child if not yet set
+		if position > 0 {
+			if len(pending) == 0 {
+				pr.err = ErrCorruptSnapshot
+				break
+			}
+			parent := &state.Nodes[pending[len(pending)-1]]
+			if parent.L == 0 {
+				parent.L = position
+			} else {
+				parent.R = position
+				pending = pending[:len(pending)-1]
+			}
+		}
+
+		node := &state.Nodes[position]
+		switch pr.byte() {
+		case binaryLeaf:
+			node.Type = "leaf"
+			node.N = int(pr.uvarint())
+			node.I = int(pr.varint())
+			node.D = int(pr.uvarint())
+			node.X = pr.floats(state.Ndim)
+		case binaryBranch:
+			node.Type = "branch"
+			node.N = int(pr.uvarint())
+			node.Q = int(pr.uvarint())
+			if p := pr.floats(1); p != nil {
+				node.P = p[0]
+			}
+			node.B = [][]float64{pr.floats(state.Ndim), pr.floats(state.Ndim)}
+			pending = append(pending, position)
+		default:
+			pr.err = ErrCorruptSnapshot
+		}
+	}
+	if pr.err == nil && len(pending) > 0 {
+		pr.err = ErrCorruptSnapshot
+	}
+	if numNodes > 0 {
+		state.Root = 0
+	}
+
+	numLeaves := pr.length(2)
+	for i := 0; i < numLeaves && pr.err == nil; i++ {
+		index := int(pr.varint())
+		state.Leaves[index] = int(pr.uvarint())
+	}
+	numOrder := pr.length(1)
+	if pr.err == nil {
+		state.Order = make([]int, numOrder)
+	}
+	for i := 0; i < numOrder && pr.err == nil; i++ {
+		state.Order[i] = int(pr.varint())
+	}
+	if pr.err == nil && len(pr.data) > 0 {
+		pr.err = ErrCorruptSnapshot
+	}
+	return state, pr.err
+}
@@ -0,0 +1,86 @@
+package rrcf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryForest(t *testing.T) {
+	forest := []RCTree{newFilingTree(1), NewRCTree(nil, nil, 0, 3), newFilingTree(2)}
+
+	var buffer bytes.Buffer
+	assert.NoError(t, WriteForest(&buffer, forest))
+
+	forestJSON, _ := json.Marshal(forest)
+	assert.Less(t, buffer.Len(), len(forestJSON), "Binary snapshot larger than json")
+
+	loaded, err := ReadForest(&buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, len(forest), len(loaded), "Wrong number of trees")
+	for i := range forest {
+		assertSameTree(t, forest[i], loaded[i])
+		assert.Equal(t, forest[i].InsertionOrder(), loaded[i].InsertionOrder(), "Wrong insertion order")
+	}
+}
+
+func TestBinaryForestFile(t *testing.T) {
+	forest := []RCTree{newFilingTree(1), newFilingTree(2)}
+	filename := filepath.Join(t.TempDir(), "forest.rrcf")
+
+	assert.NoError(t, SaveForestBinary(forest, filename))
+	loaded, err := LoadForestBinary(filename)
+	assert.NoError(t, err)
+	for i := range forest {
+		assertSameTree(t, forest[i], loaded[i])
+	}
+}
+
+func TestBinaryStreaming(t *testing.T) {
+	var buffer bytes.Buffer
+	enc := NewEncoder(&buffer)
+	assert.NoError(t, enc.Encode(newFilingTree(1)))
+	assert.NoError(t, enc.Encode(newFilingTree(2)))
+	assert.NoError(t, enc.Close())
+
+	dec := NewDecoder(&buffer)
+	for seed := 1; seed <= 2; seed++ {
+		tree, err := dec.Decode()
+		assert.NoError(t, err)
+		assertSameTree(t, newFilingTree(seed), tree)
+	}
+	_, err := dec.Decode()
+	assert.Equal(t, io.EOF, err, "End of snapshot not reported")
+}
+
+func TestBinaryErrors(t *testing.T) {
+	var buffer bytes.Buffer
+	WriteForest(&buffer, []RCTree{newFilingTree(1)})
+	snapshot := buffer.Bytes()
+
+	// Truncated snapshots
+	for _, length := range []int{len(snapshot) - 1, len(snapshot) / 2, 6} {
+		_, err := ReadForest(bytes.NewReader(snapshot[:length]))
+		assert.True(t, errors.Is(err, io.ErrUnexpectedEOF), "Truncation at %d not detected: %v", length, err)
+	}
+
+	// Corrupted payload
+	corrupted := append([]byte{}, snapshot...)
+	corrupted[len(corrupted)/2] ^= 0xff
+	_, err := ReadForest(bytes.NewReader(corrupted))
+	assert.True(t, errors.Is(err, ErrChecksum), "Corruption not detected: %v", err)
+
+	// Incompatible data
+	_, err = ReadForest(bytes.NewReader([]byte("{\"version\": 1}")))
+	assert.True(t, errors.Is(err, ErrNotSnapshot), "Json accepted as snapshot: %v", err)
+
+	newer := append([]byte{}, snapshot...)
+	newer[len(binaryMagic)] = BinaryVersion + 1
+	_, err = ReadForest(bytes.NewReader(newer))
+	assert.True(t, errors.Is(err, ErrSnapshotVersion), "Unknown version accepted: %v", err)
+}
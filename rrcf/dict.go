@@ -0,0 +1,184 @@
+package rrcf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/andysgithub/go-rrcf/array"
+)
+
+// dictBranch holds the keys written for a branch by RCTree.to_dict() in Python
+type dictBranch[K comparable] struct {
+	Type string           `json:"type"`
+	Q    int              `json:"q"`
+	P    float64          `json:"p"`
+	N    int              `json:"n"`
+	B    [][]float64      `json:"b"`
+	L    *NodeObjectOf[K] `json:"l"`
+	R    *NodeObjectOf[K] `json:"r"`
+}
+
+// dictLeaf holds the keys written for a leaf by RCTree.to_dict() in Python
+type dictLeaf[K comparable] struct {
+	Type string    `json:"type"`
+	I    int       `json:"i"`
+	X    []float64 `json:"x"`
+	D    int       `json:"d"`
+	N    int       `json:"n"`
+	Ixs  []K       `json:"ixs"`
+}
+
+// MarshalJSON encodes only the keys used by the Python library for the type of node
+func (obj NodeObjectOf[K]) MarshalJSON() ([]byte, error) {
+	switch obj.Type {
+	case "Branch":
+		return json.Marshal(dictBranch[K]{obj.Type, obj.Q, obj.P, obj.N, obj.B, obj.L, obj.R})
+	case "Leaf":
+		return json.Marshal(dictLeaf[K]{obj.Type, obj.I, obj.X, obj.D, obj.N, obj.Ixs})
+	default:
+		// An empty tree is an empty dictionary
+		return []byte("{}"), nil
+	}
+}
+
+// ToDict returns the tree as nested node objects, in the form produced by
+// RCTree.to_dict() in the Python rrcf library
+func (rct Tree[K]) ToDict() *NodeObjectOf[K] {
+	obj := &NodeObjectOf[K]{}
+	if rct.Root == nil {
+		return obj
+	}
+
+	// Collect the index labels of all points in each leaf
+	duplicates := make(map[*Node][]K)
+	for index, leaf := range rct.Leaves {
+		duplicates[leaf] = append(duplicates[leaf], index)
+	}
+	for _, ixs := range duplicates {
+		sortLabels(ixs)
+	}
+
+	serialize(rct.Root, obj, duplicates, 0)
+	return obj
+}
+
+// serialize recursively stores a node at the given depth and its children in a node object
+func serialize[K comparable](node *Node, obj *NodeObjectOf[K], duplicates map[*Node][]K, depth int) {
+	obj.N = node.n
+	if node.isBranch() {
+		obj.Type = "Branch"
+		obj.Q = node.Branch.q
+		obj.P = node.Branch.p
+		obj.B = node.b
+		obj.L = &NodeObjectOf[K]{}
+		obj.R = &NodeObjectOf[K]{}
+		serialize(node.Branch.l, obj.L, duplicates, depth+1)
+		serialize(node.Branch.r, obj.R, duplicates, depth+1)
+	} else {
+		obj.Type = "Leaf"
+		obj.I = node.Leaf.I
+		obj.X = node.Leaf.x
+		obj.D = depth
+		obj.Ixs = duplicates[node]
+	}
+}
+
+// LoadDict replaces the contents of the tree with nested node objects, in the form
+// read by RCTree.load_dict() in the Python rrcf library
+// The random state of the tree is kept for further insertions
+func (rct *Tree[K]) LoadDict(obj *NodeObjectOf[K]) error {
+	leaves := make(map[K]*Node)
+	var root *Node
+
+	if obj != nil && obj.Type != "" {
+		var err error
+		root, err = deserialize(obj, nil, leaves)
+		if err != nil {
+			return err
+		}
+	}
+
+	ndim := 0
+	for _, leaf := range leaves {
+		ndim = len(leaf.Leaf.x)
+		break
+	}
+	for index, leaf := range leaves {
+		if len(leaf.Leaf.x) != ndim {
+			return fmt.Errorf("%w: index %v (%d), tree (%d)", ErrDimensionMismatch, index, len(leaf.Leaf.x), ndim)
+		}
+	}
+
+	rct.Root = root
+	rct.Leaves = leaves
+	rct.Ndim = ndim
+	rct.IndexLabels = nil
+	rct.Parent = nil
+	// The Python library does not record the insertion order, so ascending label order is assumed
+	rct.order = nil
+	return nil
+}
+
+// deserialize recursively creates a node and its children from a node object
+func deserialize[K comparable](obj *NodeObjectOf[K], parent *Node, leaves map[K]*Node) (*Node, error) {
+	switch obj.Type {
+	case "Branch":
+		if obj.L == nil || obj.R == nil {
+			return nil, fmt.Errorf("Branch is missing a child")
+		}
+		if len(obj.B) != 2 {
+			return nil, fmt.Errorf("Branch has invalid bounding box: %v", obj.B)
+		}
+		bbox := array.VStack(append([]float64{}, obj.B[0]...), append([]float64{}, obj.B[1]...))
+		branch := NewBranch(obj.Q, obj.P, nil, nil, parent, obj.N, bbox)
+		l, err := deserialize(obj.L, branch, leaves)
+		if err != nil {
+			return nil, err
+		}
+		r, err := deserialize(obj.R, branch, leaves)
+		if err != nil {
+			return nil, err
+		}
+		branch.Branch.l = l
+		branch.Branch.r = r
+		return branch, nil
+	case "Leaf":
+		leaf := NewLeaf(obj.I, parent, append([]float64{}, obj.X...), obj.N)
+		for _, index := range obj.Ixs {
+			if _, exists := leaves[index]; exists {
+				return nil, fmt.Errorf("%w: %v", ErrDuplicateIndex, index)
+			}
+			leaves[index] = leaf
+		}
+		return leaf, nil
+	default:
+		return nil, fmt.Errorf("Node type not recognised: %q", obj.Type)
+	}
+}
+
+// SaveDict saves a tree as json data in the Python rrcf to_dict() form to the specified file
+func SaveDict(tree RCTree, filename string) error {
+	dictJSON, err := json.Marshal(tree.ToDict())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, dictJSON, 0644)
+}
+
+// LoadDictFile loads a tree from json data in the Python rrcf to_dict() form,
+// using the given random state for any further insertions
+func LoadDictFile(filename string, randomState interface{}) (RCTree, error) {
+	tree := NewRCTree(nil, nil, 0, randomState)
+
+	dictJSON, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return tree, err
+	}
+	obj := NewNodeObject()
+	if err := json.Unmarshal(dictJSON, obj); err != nil {
+		return tree, err
+	}
+	err = tree.LoadDict(obj)
+	return tree, err
+}
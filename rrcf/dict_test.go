@@ -0,0 +1,71 @@
+package rrcf
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDictFile checks a tree loaded from the nested dictionary form against codisp
+// values worked by hand for the tree, which has a leaf holding two labels
+func TestDictFile(t *testing.T) {
+	tree, err := LoadDictFile("testdata/dict_tree.json", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, tree.Ndim, "Wrong dimension")
+	assert.Equal(t, 6, len(tree.Leaves), "Wrong number of leaves")
+	assert.Same(t, tree.Leaves[0], tree.Leaves[4], "Duplicate points not sharing a leaf")
+
+	codispJSON, _ := ioutil.ReadFile("testdata/dict_codisp.json")
+	var expected map[string]float64
+	json.Unmarshal(codispJSON, &expected)
+	for key, value := range expected {
+		index, _ := strconv.Atoi(key)
+		codisp, err := tree.CoDisp(index)
+		assert.NoError(t, err)
+		assert.Equal(t, value, codisp, "Wrong codisp for leaf %d", index)
+	}
+
+	// Exporting the tree again gives the same dictionary
+	filename := filepath.Join(t.TempDir(), "tree.json")
+	assert.NoError(t, SaveDict(tree, filename))
+	var original, exported interface{}
+	originalJSON, _ := ioutil.ReadFile("testdata/dict_tree.json")
+	exportedJSON, _ := ioutil.ReadFile(filename)
+	json.Unmarshal(originalJSON, &original)
+	json.Unmarshal(exportedJSON, &exported)
+	assert.Equal(t, original, exported, "Exported dictionary differs from loaded dictionary")
+
+	// The loaded tree can continue streaming
+	_, err = tree.InsertPoint([]float64{2, 3}, 6, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, tree.Root.n, "Wrong count after insertion")
+}
+
+func TestDictRoundTrip(t *testing.T) {
+	tree := newFilingTree(1)
+
+	dictJSON, err := json.Marshal(tree.ToDict())
+	assert.NoError(t, err)
+	obj := NewNodeObject()
+	assert.NoError(t, json.Unmarshal(dictJSON, obj))
+
+	loaded := NewRCTree(nil, nil, 0, 0)
+	assert.NoError(t, loaded.LoadDict(obj))
+	assertSameTree(t, tree, loaded)
+}
+
+func TestEmptyDict(t *testing.T) {
+	tree := NewRCTree(nil, nil, 0, 0)
+
+	dictJSON, _ := json.Marshal(tree.ToDict())
+	assert.Equal(t, "{}", string(dictJSON), "Empty tree not an empty dictionary")
+
+	obj := NewNodeObject()
+	json.Unmarshal(dictJSON, obj)
+	assert.NoError(t, tree.LoadDict(obj))
+	assert.Nil(t, tree.Root, "Empty tree has a root")
+}
@@ -5,24 +5,63 @@ import (
 	"io/ioutil"
 )
 
+// MarshalJSON encodes the complete state of the tree as json
+func (rct Tree[K]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(rct.State())
+}
+
+// UnmarshalJSON rebuilds the tree from json produced by MarshalJSON
+func (rct *Tree[K]) UnmarshalJSON(data []byte) error {
+	var state TreeStateOf[K]
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	tree, err := NewTreeFromState(state)
+	if err != nil {
+		return err
+	}
+	*rct = tree
+	return nil
+}
+
 // SaveTree saves a tree as json data to the specified file
-func SaveTree(tree RCTree, filename string) {
-	treeJSON, _ := json.MarshalIndent(tree, "", " ")
-	ioutil.WriteFile(filename, treeJSON, 0644)
+func SaveTree(tree RCTree, filename string) error {
+	treeJSON, err := json.MarshalIndent(tree, "", " ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, treeJSON, 0644)
 }
 
 // SaveForest saves a forest as json data to the specified file
-func SaveForest(forest []RCTree, filename string) {
-	forestJSON, _ := json.MarshalIndent(forest, "", " ")
-	ioutil.WriteFile(filename, forestJSON, 0644)
+func SaveForest(forest []RCTree, filename string) error {
+	forestJSON, err := json.MarshalIndent(forest, "", " ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, forestJSON, 0644)
 }
 
-// LoadTree -
-func LoadTree() {
+// LoadTree loads a tree from json data saved by SaveTree
+func LoadTree(filename string) (RCTree, error) {
+	var tree RCTree
 
+	treeJSON, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return tree, err
+	}
+	err = json.Unmarshal(treeJSON, &tree)
+	return tree, err
 }
 
-// LoadForest -
-func LoadForest() {
+// LoadForest loads a forest from json data saved by SaveForest
+func LoadForest(filename string) ([]RCTree, error) {
+	var forest []RCTree
 
+	forestJSON, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(forestJSON, &forest)
+	return forest, err
 }
@@ -0,0 +1,140 @@
+package rrcf
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/andysgithub/go-rrcf/random"
+	"github.com/stretchr/testify/assert"
+)
+
+// newFilingTree builds a seeded tree from batch data then streams in further points,
+// including duplicates
+func newFilingTree(seed int) RCTree {
+	rnd := random.NewRandomState(int64(seed))
+	tree := NewRCTree(rnd.Normal2D(50, 3), nil, 9, seed)
+	for index := 50; index < 60; index++ {
+		tree.InsertPoint(rnd.Normal1D(3), index, 0)
+	}
+	tree.InsertPoint([]float64{1, 1, 1}, 60, 0)
+	tree.InsertPoint([]float64{1, 1, 1}, 61, 0)
+	tree.ForgetPoint(3)
+	return tree
+}
+
+// assertSameTree checks that two trees give identical scores and bounding boxes
+func assertSameTree(t *testing.T, expected RCTree, actual RCTree) {
+	assert.Equal(t, len(expected.Leaves), len(actual.Leaves), "Wrong number of leaves")
+	assert.Equal(t, expected.Ndim, actual.Ndim, "Wrong dimension")
+	for index, leaf := range expected.Leaves {
+		loaded, ok := actual.Leaves[index]
+		if !assert.True(t, ok, fmt.Sprintf("Leaf %d missing", index)) {
+			continue
+		}
+		assert.Equal(t, leaf.Leaf.x, loaded.Leaf.x, "Wrong point for leaf %d", index)
+		assert.Equal(t, leaf.depth(), loaded.depth(), "Wrong depth for leaf %d", index)
+		assert.Equal(t, leaf.n, loaded.n, "Wrong count for leaf %d", index)
+
+		codisp, _ := expected.CoDisp(index)
+		loadedCodisp, _ := actual.CoDisp(index)
+		assert.Equal(t, codisp, loadedCodisp, "Wrong codisp for leaf %d", index)
+	}
+
+	if expected.Root == nil {
+		assert.Nil(t, actual.Root, "Empty tree has a root")
+		return
+	}
+	var branches, loadedBranches []Node
+	branches = expected.MapBranches(expected.Root, branches)
+	loadedBranches = actual.MapBranches(actual.Root, loadedBranches)
+	assert.Equal(t, len(branches), len(loadedBranches), "Wrong number of nodes")
+	for i := range branches {
+		assert.Equal(t, branches[i].b, loadedBranches[i].b, "Wrong bounding box")
+		assert.Equal(t, branches[i].n, loadedBranches[i].n, "Wrong leaf count")
+	}
+}
+
+func TestSaveLoadTree(t *testing.T) {
+	tree := newFilingTree(1)
+	filename := filepath.Join(t.TempDir(), "tree.json")
+
+	err := SaveTree(tree, filename)
+	assert.NoError(t, err)
+	loaded, err := LoadTree(filename)
+	assert.NoError(t, err)
+	assertSameTree(t, tree, loaded)
+
+	// The restored random state continues with the same cuts
+	rnd := random.NewRandomState(7)
+	for index := 100; index < 120; index++ {
+		point := rnd.Normal1D(3)
+		tree.InsertPoint(point, index, 0)
+		loaded.InsertPoint(point, index, 0)
+	}
+	assertSameTree(t, tree, loaded)
+
+	// The random state has the same size however many values have been drawn
+	assert.Len(t, tree.State().Rng, len(NewRCTree(nil, nil, 0, 3).State().Rng))
+}
+
+func TestSaveLoadEmptyTree(t *testing.T) {
+	tree := NewRCTree(nil, nil, 0, 3)
+	filename := filepath.Join(t.TempDir(), "tree.json")
+
+	assert.NoError(t, SaveTree(tree, filename))
+	loaded, err := LoadTree(filename)
+	assert.NoError(t, err)
+	assert.Nil(t, loaded.Root, "Empty tree has a root")
+	assert.Equal(t, 0, len(loaded.Leaves), "Empty tree has leaves")
+}
+
+func TestSaveLoadForest(t *testing.T) {
+	forest := []RCTree{newFilingTree(1), newFilingTree(2), NewRCTree(nil, nil, 0, 3)}
+	filename := filepath.Join(t.TempDir(), "forest.json")
+
+	assert.NoError(t, SaveForest(forest, filename))
+	loaded, err := LoadForest(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, len(forest), len(loaded), "Wrong number of trees")
+	for i := range forest {
+		assertSameTree(t, forest[i], loaded[i])
+	}
+}
+
+func TestLoadInvalidState(t *testing.T) {
+	state := newFilingTree(1).State()
+	state.Nodes[0].L = 0
+	_, err := NewRCTreeFromState(state)
+	assert.Error(t, err, "Cyclic tree accepted")
+
+	state = newFilingTree(1).State()
+	state.Version = StateVersion + 1
+	_, err = NewRCTreeFromState(state)
+	assert.Error(t, err, "Unknown version accepted")
+
+	state = newFilingTree(1).State()
+	state.Rng = state.Rng[:4]
+	_, err = NewRCTreeFromState(state)
+	assert.Error(t, err, "Truncated random state accepted")
+
+	state = newFilingTree(1).State()
+	state.Nodes[0].N++
+	_, err = NewRCTreeFromState(state)
+	assert.Error(t, err, "Wrong branch count accepted")
+
+	// The last leaf in pre-order is reached by the right child of each branch above it
+	state = newFilingTree(1).State()
+	position := 0
+	for ; state.Nodes[position].Type == "branch"; position = state.Nodes[position].R {
+		state.Nodes[position].N++
+	}
+	state.Nodes[position].N++
+	_, err = NewRCTreeFromState(state)
+	assert.Error(t, err, "Wrong leaf count accepted")
+
+	state = newFilingTree(1).State()
+	state.Nodes[len(state.Nodes)-1].D++
+	_, err = NewRCTreeFromState(state)
+	assert.Error(t, err, "Wrong leaf depth accepted")
+}
@@ -2,24 +2,19 @@ package rrcf
 
 import "github.com/andysgithub/go-rrcf/array"
 
-// IncrementDepth increments the depth attribute of a leaf
-func (rcTree RCTree) IncrementDepth(node *Node, increment int) {
-	node.Leaf.d += increment
-}
-
 // Accumulate counts the number of points in a subtree
-func (rcTree RCTree) Accumulate(node *Node, accumulator *int) {
+func (rcTree Tree[K]) Accumulate(node *Node, accumulator *int) {
 	*accumulator += node.n
 }
 
-// GetNodes accumulates a list of all leaves in a subtree
-func (rcTree RCTree) GetNodes(node *Node, stack []Node) []Node {
+// GetNodes appends a node to a list of nodes
+func (rcTree Tree[K]) GetNodes(node *Node, stack []Node) []Node {
 	stack = append(stack, *node)
 	return stack
 }
 
 // ComputeBbox computes the bbox of a point
-func (rcTree RCTree) ComputeBbox(x *Node, mins []float64, maxes []float64) {
+func (rcTree Tree[K]) ComputeBbox(x *Node, mins []float64, maxes []float64) {
 	lt := array.LtFloat(x.Leaf.x, mins)
 	gt := array.GtFloat(x.Leaf.x, maxes)
 
@@ -29,7 +24,7 @@ func (rcTree RCTree) ComputeBbox(x *Node, mins []float64, maxes []float64) {
 
 // RemoveIndex removes the element at index and move all later values up
 // Returns the element removed
-func RemoveIndex(s map[int]*Node, index int) *Node {
+func RemoveIndex[K comparable](s map[K]*Node, index K) *Node {
 	element := s[index]
 	delete(s, index)
 	return element
@@ -0,0 +1,80 @@
+package rrcf
+
+import (
+	"fmt"
+	"math"
+)
+
+// Impute returns a completion of a point whose missing dimensions are set to NaN
+//
+// The point is passed down the tree as for Query, except that both children are
+// followed at any branch cutting a missing dimension. The missing values are taken
+// from the leaf reached that is nearest to the point in the known dimensions, with
+// equally near leaves weighted by the number of points in each leaf.
+// Known values are returned unchanged.
+func (rct Tree[K]) Impute(point []float64) ([]float64, error) {
+	if rct.Root == nil {
+		return nil, ErrEmptyTree
+	}
+	if len(point) != rct.Ndim {
+		return nil, fmt.Errorf("%w: point (%d), tree (%d)", ErrDimensionMismatch, len(point), rct.Ndim)
+	}
+
+	imputed := append([]float64(nil), point...)
+	missing := false
+	for _, value := range point {
+		missing = missing || math.IsNaN(value)
+	}
+	if !missing {
+		return imputed, nil
+	}
+
+	sums := make([]float64, rct.Ndim)
+	var mass float64
+	nearest := math.Inf(1)
+	imputeLeaves(point, rct.Root, func(leaf *Node) {
+		// Manhattan distance over the known dimensions
+		var distance float64
+		for dim, value := range point {
+			if !math.IsNaN(value) {
+				distance += math.Abs(leaf.Leaf.x[dim] - value)
+			}
+		}
+		if distance > nearest {
+			return
+		}
+		if distance < nearest {
+			nearest = distance
+			mass = 0
+			for dim := range sums {
+				sums[dim] = 0
+			}
+		}
+		for dim, value := range leaf.Leaf.x {
+			sums[dim] += value * float64(leaf.n)
+		}
+		mass += float64(leaf.n)
+	})
+
+	for dim, value := range point {
+		if math.IsNaN(value) {
+			imputed[dim] = sums[dim] / mass
+		}
+	}
+	return imputed, nil
+}
+
+// imputeLeaves recursively visits the leaves consistent with the known values of a point
+func imputeLeaves(point []float64, node *Node, visit func(leaf *Node)) {
+	if node.isLeaf() {
+		visit(node)
+		return
+	}
+	value := point[node.Branch.q]
+	if math.IsNaN(value) || value <= node.Branch.p {
+		imputeLeaves(point, node.Branch.l, visit)
+	}
+	if math.IsNaN(value) || value > node.Branch.p {
+		imputeLeaves(point, node.Branch.r, visit)
+	}
+}
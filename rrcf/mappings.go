@@ -1,7 +1,7 @@
 package rrcf
 
 // MapLeaves traverses the tree recursively, calling Accumulate on leaves
-func (rcTree RCTree) MapLeaves(node *Node, accumulator *int) {
+func (rcTree Tree[K]) MapLeaves(node *Node, accumulator *int) {
 	if node.isBranch() {
 
 		// Process without recursion if both children are leaves
@@ -23,14 +23,12 @@ func (rcTree RCTree) MapLeaves(node *Node, accumulator *int) {
 }
 
 // MapBranches traverses the tree recursively, calling GetNodes on branches
-func (rcTree RCTree) MapBranches(node *Node, branches []Node) []Node {
+func (rcTree Tree[K]) MapBranches(node *Node, branches []Node) []Node {
 	if node.isBranch() {
 
 		// Process without recursion if both children are leaves
 		if node.Branch.l.isLeaf() && node.Branch.r.isLeaf() {
-			branches = rcTree.GetNodes(node.Branch.l, branches)
-			branches = rcTree.GetNodes(node.Branch.r, branches)
-			return branches
+			return rcTree.GetNodes(node, branches)
 		}
 
 		if node.Branch.l != nil {
@@ -45,7 +43,7 @@ func (rcTree RCTree) MapBranches(node *Node, branches []Node) []Node {
 }
 
 // MapBboxes traverses the tree recursively, calling GetBbox on leaves
-func (rcTree RCTree) MapBboxes(node *Node, mins []float64, maxes []float64) {
+func (rcTree Tree[K]) MapBboxes(node *Node, mins []float64, maxes []float64) {
 	if node.isBranch() {
 
 		// Process without recursion if both children are leaves
@@ -65,25 +63,3 @@ func (rcTree RCTree) MapBboxes(node *Node, mins []float64, maxes []float64) {
 		rcTree.ComputeBbox(node, mins, maxes)
 	}
 }
-
-// MapDepths traverses the tree recursively, calling IncrementDepth on leaves
-func (rcTree RCTree) MapDepths(node *Node, inc int) {
-	if node.isBranch() {
-
-		// Process without recursion if both children are leaves
-		if node.Branch.l.isLeaf() && node.Branch.r.isLeaf() {
-			rcTree.IncrementDepth(node.Branch.l, inc)
-			rcTree.IncrementDepth(node.Branch.r, inc)
-			return
-		}
-
-		if node.Branch.l != nil {
-			rcTree.MapDepths(node.Branch.l, inc)
-		}
-		if node.Branch.r != nil {
-			rcTree.MapDepths(node.Branch.r, inc)
-		}
-	} else {
-		rcTree.IncrementDepth(node, inc)
-	}
-}
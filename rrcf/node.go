@@ -22,26 +22,29 @@ type Branch struct {
 
 // Leaf of RCTree containing zero children
 type Leaf struct {
-	I int       // Index of leaf (user-specified)
-	d int       // Depth of leaf
+	I int       // Index of leaf (user-specified), or -1 for non-integer labels
 	x []float64 // Original point
 }
 
-// NodeObject stores a leaf or branch along with the node type
-type NodeObject struct {
-	nodeType string      // Type of node - 'Leaf' or 'Branch'
-	q        int         // Dimension of cut
-	p        float64     // Value of cut
-	l        *Node       // Pointer to left child
-	r        *Node       // Pointer to right child
-	b        [][]float64 // Bounding box of single point or points under branch
-	i        int         // Index of leaf (user-specified)
-	d        int         // Depth of leaf
-	x        []float64   // Original point
-	n        int         // Number of leaves under branch or points in leaf
-	ixs      int
+// NodeObjectOf stores a leaf or branch along with the node type, for index labels of type K
+// It follows the keys of the nested dictionary of RCTree.to_dict() in the Python rrcf library
+type NodeObjectOf[K comparable] struct {
+	Type string           `json:"type"` // Type of node - 'Leaf' or 'Branch'
+	Q    int              `json:"q"`    // Dimension of cut
+	P    float64          `json:"p"`    // Value of cut
+	L    *NodeObjectOf[K] `json:"l"`    // Left child
+	R    *NodeObjectOf[K] `json:"r"`    // Right child
+	B    [][]float64      `json:"b"`    // Bounding box of points under branch
+	I    int              `json:"i"`    // Index of leaf (user-specified)
+	D    int              `json:"d"`    // Depth of leaf
+	X    []float64        `json:"x"`    // Original point
+	N    int              `json:"n"`    // Number of leaves under branch or points in leaf
+	Ixs  []K              `json:"ixs"`  // Index labels of all points in leaf
 }
 
+// NodeObject stores a leaf or branch of an RCTree along with the node type
+type NodeObject = NodeObjectOf[int]
+
 // NewBranch defines a new branch of a tree
 func NewBranch(q int, p float64, l *Node, r *Node, u *Node, n int, b [][]float64) *Node {
 	node := Node{
@@ -55,9 +58,9 @@ func NewBranch(q int, p float64, l *Node, r *Node, u *Node, n int, b [][]float64
 }
 
 // NewLeaf defines a new leaf of a branch
-func NewLeaf(i int, d int, u *Node, x []float64, n int) *Node {
+func NewLeaf(i int, u *Node, x []float64, n int) *Node {
 	node := Node{
-		&Leaf{i, d, x},
+		&Leaf{i, x},
 		nil,
 		array.ReshapeRow(x),
 		u,
@@ -83,3 +86,14 @@ func (node *Node) isBranch() bool {
 func (node *Node) isRoot() bool {
 	return node.u == nil
 }
+
+// depth returns the number of branches above the node
+// Depths are found on demand rather than stored, so that inserting or forgetting a
+// point does not need to update the depths of the leaves below it
+func (node *Node) depth() int {
+	depth := 0
+	for ; !node.isRoot(); node = node.u {
+		depth++
+	}
+	return depth
+}
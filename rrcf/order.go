@@ -0,0 +1,151 @@
+package rrcf
+
+import (
+	"fmt"
+	"sort"
+)
+
+// insertionOrder records the order in which index labels were inserted into a tree
+//
+// Forgotten labels are left in the queue and skipped when they reach the front,
+// so that any leaf can be forgotten in constant time. Each insertion is given a
+// sequence number, so a label that is forgotten and inserted again is queued anew.
+type insertionOrder[K comparable] struct {
+	queue    []orderEntry[K] // Index labels in insertion order, including forgotten labels
+	inserted map[K]uint64    // Sequence number of the current insertion of each label
+	sequence uint64          // Sequence number of the latest insertion
+}
+
+// orderEntry records one insertion of an index label
+type orderEntry[K comparable] struct {
+	index    K
+	sequence uint64
+}
+
+// newInsertionOrder returns an insertion order holding the labels in the given order
+func newInsertionOrder[K comparable](labels []K) *insertionOrder[K] {
+	order := &insertionOrder[K]{inserted: make(map[K]uint64, len(labels))}
+	for _, index := range labels {
+		order.push(index)
+	}
+	return order
+}
+
+// push records the insertion of an index label
+func (order *insertionOrder[K]) push(index K) {
+	order.sequence++
+	order.inserted[index] = order.sequence
+	order.queue = append(order.queue, orderEntry[K]{index, order.sequence})
+	order.compact()
+}
+
+// remove records that an index label has been forgotten
+func (order *insertionOrder[K]) remove(index K) {
+	delete(order.inserted, index)
+	order.compact()
+}
+
+// current reports whether an entry is the latest insertion of a label still in the tree
+func (order *insertionOrder[K]) current(entry orderEntry[K]) bool {
+	sequence, ok := order.inserted[entry.index]
+	return ok && sequence == entry.sequence
+}
+
+// oldest returns the earliest inserted label still in the tree
+func (order *insertionOrder[K]) oldest() (K, bool) {
+	for len(order.queue) > 0 && !order.current(order.queue[0]) {
+		order.queue = order.queue[1:]
+	}
+	if len(order.queue) == 0 {
+		var none K
+		return none, false
+	}
+	return order.queue[0].index, true
+}
+
+// labels returns the labels still in the tree, oldest first
+func (order *insertionOrder[K]) labels() []K {
+	labels := make([]K, 0, len(order.inserted))
+	for _, entry := range order.queue {
+		if order.current(entry) {
+			labels = append(labels, entry.index)
+		}
+	}
+	return labels
+}
+
+// compact drops forgotten labels once they make up most of the queue
+func (order *insertionOrder[K]) compact() {
+	if len(order.queue) <= 2*len(order.inserted)+32 {
+		return
+	}
+	queue := make([]orderEntry[K], 0, len(order.inserted))
+	for _, entry := range order.queue {
+		if order.current(entry) {
+			queue = append(queue, entry)
+		}
+	}
+	order.queue = queue
+}
+
+// sortedLabels returns the labels of the leaves in ascending order
+func sortedLabels[K comparable](leaves map[K]*Node) []K {
+	labels := make([]K, 0, len(leaves))
+	for index := range leaves {
+		labels = append(labels, index)
+	}
+	sortLabels(labels)
+	return labels
+}
+
+// sortLabels sorts index labels in ascending order
+// Integer and string labels are sorted by value, and other labels by their printed form
+func sortLabels[K comparable](labels []K) {
+	switch sorted := any(labels).(type) {
+	case []int:
+		sort.Ints(sorted)
+	case []string:
+		sort.Strings(sorted)
+	default:
+		sort.SliceStable(labels, func(i, j int) bool {
+			return fmt.Sprint(labels[i]) < fmt.Sprint(labels[j])
+		})
+	}
+}
+
+// insertionOrder returns the order of the tree, starting from the labels in ascending
+// order if the tree was not created with one
+func (rct *Tree[K]) insertionOrder() *insertionOrder[K] {
+	if rct.order == nil {
+		rct.order = newInsertionOrder(sortedLabels(rct.Leaves))
+	}
+	return rct.order
+}
+
+// InsertionOrder returns the index labels of the leaves in the order they were inserted,
+// oldest first
+// Points of a batch-built tree are ordered as in the source data, and trees without
+// a recorded order are taken to have been inserted in ascending label order
+func (rct Tree[K]) InsertionOrder() []K {
+	if rct.order == nil {
+		return sortedLabels(rct.Leaves)
+	}
+	return rct.order.labels()
+}
+
+// Oldest returns the index label of the earliest inserted point still in the tree
+// Returns false if the tree is empty
+func (rct *Tree[K]) Oldest() (K, bool) {
+	return rct.insertionOrder().oldest()
+}
+
+// ForgetOldest deletes the earliest inserted point still in the tree, returning its index label
+// Returns ErrEmptyTree if there are no points in the tree
+func (rct *Tree[K]) ForgetOldest() (K, error) {
+	index, ok := rct.Oldest()
+	if !ok {
+		return index, ErrEmptyTree
+	}
+	_, err := rct.ForgetPoint(index)
+	return index, err
+}
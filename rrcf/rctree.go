@@ -4,55 +4,97 @@ import (
 	"errors"
 	"fmt"
 	"math"
-	"time"
 
 	"github.com/andysgithub/go-rrcf/array"
 	"github.com/andysgithub/go-rrcf/random"
 )
 
-// RCTree - Robust Random Cut Forest
-type RCTree struct {
-	Leaves      map[int]*Node       // Map containing pointers to all leaves in tree
+var (
+	// ErrNoSuchLeaf is returned when an index is not in the leaves map
+	ErrNoSuchLeaf = errors.New("No such leaf index")
+	// ErrDuplicateIndex is returned when inserting an index already in the leaves map
+	ErrDuplicateIndex = errors.New("Index already exists in leaves map")
+	// ErrDimensionMismatch is returned when a point does not match the dimension of the tree
+	ErrDimensionMismatch = errors.New("Point dimension not equal to existing points in tree")
+	// ErrEmptyTree is returned when an operation needs points in the tree
+	ErrEmptyTree = errors.New("Tree has no points")
+)
+
+// Tree - Robust Random Cut Tree with index labels of any comparable type
+type Tree[K comparable] struct {
+	Leaves      map[K]*Node         // Map containing pointers to all leaves in tree
 	Root        *Node               // Pointer to root of tree
 	Ndim        int                 // Dimension of points in the tree
-	IndexLabels []int               // Index labels
+	IndexLabels []K                 // Index labels
 	Parent      *Node               // Parent of the current node
 	Rng         *random.RandomState // RandomState instance for random operations
+
+	order *insertionOrder[K] // Order in which the index labels were inserted
 }
 
+// RCTree - Robust Random Cut Tree with integer index labels
+type RCTree = Tree[int]
+
 // NewRCTree returns a new random cut forest
 func NewRCTree(X [][]float64, indexLabels []int, precision int, randomState interface{}) RCTree {
-	rct := RCTree{
-		make(map[int]*Node),
-		nil, 0, nil, nil, nil,
+	rct := newTree[int](randomState)
+	rct.Init(X, indexLabels, precision)
+	return rct
+}
+
+// NewTree returns a new random cut tree with index labels of any comparable type
+// Each row of X must have an index label, unless the labels are integers
+func NewTree[K comparable](X [][]float64, indexLabels []K, precision int, randomState interface{}) (Tree[K], error) {
+	rct := newTree[K](randomState)
+	if X != nil && len(indexLabels) != len(X) && (indexLabels != nil || defaultLabels[K](len(X)) == nil) {
+		return rct, fmt.Errorf("Index labels (%d) do not match rows of data (%d)", len(indexLabels), len(X))
 	}
+	rct.Init(X, indexLabels, precision)
+	return rct, nil
+}
 
-	switch randomState.(type) {
-	case int:
-		// Random number generation with provided seed
-		rct.Rng = random.NewRandomState(int64(randomState.(int)))
-	case *random.RandomState:
-		// The existing RandomState instance
-		rct.Rng = randomState.(*random.RandomState)
-	default:
-		// Random number generation with random seed
-		rct.Rng = random.NewRandomState(time.Now().UTC().UnixNano())
+func newTree[K comparable](randomState interface{}) Tree[K] {
+	return Tree[K]{
+		make(map[K]*Node),
+		nil, 0, nil, nil,
+		random.NewRandomStateFrom(randomState),
+		newInsertionOrder[K](nil),
 	}
+}
 
-	rct.Init(X, indexLabels, precision)
-	return rct
+// defaultLabels returns the labels 0 to n-1, or nil if the labels are not integers
+func defaultLabels[K comparable](n int) []K {
+	labels, _ := any(array.Arange(n)).([]K)
+	return labels
+}
+
+// leafIndex returns the index stored in a leaf for a label, or -1 for non-integer labels
+func leafIndex[K comparable](index K) int {
+	if i, ok := any(index).(int); ok {
+		return i
+	}
+	return -1
 }
 
 // Init - Initialises the random cut forest
-func (rct *RCTree) Init(X [][]float64, indexLabels []int, precision int) {
+func (rct *Tree[K]) Init(X [][]float64, indexLabels []K, precision int) {
 	if X != nil {
 		// Round data to avoid sorting errors
 		X = array.Around(X, precision)
 		if indexLabels == nil {
-			indexLabels = array.Arange(len(X))
+			indexLabels = defaultLabels[K](len(X))
 		}
 		rct.IndexLabels = indexLabels
 
+		// A cut needs two distinct points, so a single distinct point is inserted as if
+		// streamed
+		if unique, _, _ := array.Unique(X); len(unique) < 2 {
+			for j, point := range X {
+				rct.InsertPoint(point, indexLabels[j], 0)
+			}
+			return
+		}
+
 		// Remove duplicated rows
 		X, I, N := array.Unique(X)
 
@@ -67,7 +109,7 @@ func (rct *RCTree) Init(X [][]float64, indexLabels []int, precision int) {
 
 		// Create RRC Tree
 		S := array.OnesBool(dataRows)
-		rct.MakeTree(X, S, N, I, rct.Root, "root", 0)
+		rct.MakeTree(X, S, N, I, rct.Root, "root")
 
 		// Remove parent of root
 		rct.Root.u = nil
@@ -75,23 +117,30 @@ func (rct *RCTree) Init(X [][]float64, indexLabels []int, precision int) {
 		rct.CountAllTopDown(rct.Root)
 		// Set bboxes of all branches
 		rct.GetBboxTopDown(rct.Root)
+
+		// Record the points as inserted in the order of the data
+		labels := make([]K, 0, len(rct.Leaves))
+		for _, index := range indexLabels {
+			if _, ok := rct.Leaves[index]; ok {
+				labels = append(labels, index)
+			}
+		}
+		rct.order = newInsertionOrder(labels)
 	}
 }
 
 // MakeTree generates a random cut tree
-func (rct *RCTree) MakeTree(X [][]float64, S []bool, N []int, I []int, parent *Node, side string, depth int) {
-	// Increment depth as we traverse down
-	depth++
+func (rct *Tree[K]) MakeTree(X [][]float64, S []bool, N []int, I []int, parent *Node, side string) {
 	// Create a cut according to definition 1
 	S1, S2, node := rct.Cut(X, S, parent, side)
 	// If S1 does not contain an isolated point
 	if array.SumTrue(S1) > 1 {
 		// Recursively construct tree on S1
-		rct.MakeTree(X, S1, N, I, node, "l", depth)
+		rct.MakeTree(X, S1, N, I, node, "l")
 	} else {
 		// Create a leaf node from the isolated point
 		i := int(array.AsScalar(array.FlatNonZero(S1)))
-		leaf := NewLeaf(i, depth, node, X[i][:], N[i])
+		leaf := NewLeaf(i, node, X[i][:], N[i])
 		// Link leaf node to parent
 		node.Branch.l = leaf
 		// If duplicates exist
@@ -99,23 +148,21 @@ func (rct *RCTree) MakeTree(X [][]float64, S []bool, N []int, I []int, parent *N
 			// Add a key in the leaves map pointing to leaf for all duplicate indices
 			J := array.FlatNonZero(array.EqualInt(I, i))
 			// Get index label
-			J = array.IndicesInt(rct.IndexLabels, J)
 			for _, j := range J {
-				rct.Leaves[j] = leaf
+				rct.Leaves[rct.IndexLabels[j]] = leaf
 			}
 		} else {
-			i = rct.IndexLabels[i]
-			rct.Leaves[i] = leaf
+			rct.Leaves[rct.IndexLabels[i]] = leaf
 		}
 	}
 	// If S2 does not contain an isolated point
 	if array.SumTrue(S2) > 1 {
 		// Recursively construct tree on S2
-		rct.MakeTree(X, S2, N, I, node, "r", depth)
+		rct.MakeTree(X, S2, N, I, node, "r")
 	} else {
 		// Create a leaf node from isolated point
 		i := array.AsScalar(array.FlatNonZero(S2))
-		leaf := NewLeaf(i, depth, node, X[i][:], N[i])
+		leaf := NewLeaf(i, node, X[i][:], N[i])
 		// Link leaf node to parent
 		node.Branch.r = leaf
 		// If duplicates exist
@@ -123,20 +170,17 @@ func (rct *RCTree) MakeTree(X [][]float64, S []bool, N []int, I []int, parent *N
 			// Add a key in the leaves map pointing to leaf for all duplicate indices
 			J := array.FlatNonZero(array.EqualInt(I, i))
 			// Get index label
-			J = array.IndicesInt(rct.IndexLabels, J)
 			for _, j := range J {
-				rct.Leaves[j] = leaf
+				rct.Leaves[rct.IndexLabels[j]] = leaf
 			}
 		} else {
-			i = rct.IndexLabels[i]
-			rct.Leaves[i] = leaf
+			rct.Leaves[rct.IndexLabels[i]] = leaf
 		}
 	}
-	depth--
 }
 
 // Cut creates a child node to the left or right of the parent
-func (rct *RCTree) Cut(X [][]float64, S []bool, parent *Node, side string) ([]bool, []bool, *Node) {
+func (rct *Tree[K]) Cut(X [][]float64, S []bool, parent *Node, side string) ([]bool, []bool, *Node) {
 	subset := array.WhereTrueFloat(X, S)
 	// Find max and min over all d dimensions
 	xmax := array.MaxColValues(subset)
@@ -177,21 +221,34 @@ func (rct *RCTree) Cut(X [][]float64, S []bool, parent *Node, side string) ([]bo
 }
 
 // ForgetPoint deletes a leaf from the tree
-func (rct *RCTree) ForgetPoint(index int) *Node {
+// Returns ErrNoSuchLeaf if the index is not in the leaves map
+func (rct *Tree[K]) ForgetPoint(index K) (*Node, error) {
+	order := rct.insertionOrder()
+	node, err := rct.forgetPoint(index)
+	if err == nil {
+		order.remove(index)
+	}
+	return node, err
+}
+
+func (rct *Tree[K]) forgetPoint(index K) (*Node, error) {
 	// Get leaf from the leaves array
-	node := rct.Leaves[index]
+	node, ok := rct.Leaves[index]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNoSuchLeaf, index)
+	}
 	// If duplicate points exist
 	if node.n > 1 {
 		// Decrement the number of points in the leaf and for all branches above
 		rct.UpdateLeafCountUpwards(node, -1)
-		return RemoveIndex(rct.Leaves, index)
+		return RemoveIndex(rct.Leaves, index), nil
 	}
 
 	// If node is the root
 	if node.isRoot() {
 		rct.Root = nil
 		rct.Ndim = 0
-		return RemoveIndex(rct.Leaves, index)
+		return RemoveIndex(rct.Leaves, index), nil
 	}
 
 	// Find parent
@@ -206,13 +263,7 @@ func (rct *RCTree) ForgetPoint(index int) *Node {
 		// Set sibling as new root
 		sibling.u = nil
 		rct.Root = sibling
-		// Update depths
-		if sibling.isLeaf() {
-			sibling.Leaf.d = 0
-		} else {
-			rct.MapDepths(sibling, -1)
-		}
-		return RemoveIndex(rct.Leaves, index)
+		return RemoveIndex(rct.Leaves, index), nil
 	}
 	// Find grandparent
 	grandparent := parent.u
@@ -224,19 +275,17 @@ func (rct *RCTree) ForgetPoint(index int) *Node {
 	} else {
 		grandparent.Branch.r = sibling
 	}
-	// Update depths
 	parent = grandparent
-	rct.MapDepths(sibling, -1)
 	// Update leaf counts under each branch
 	rct.UpdateLeafCountUpwards(parent, -1)
 	// Update bounding boxes
 	point := node.Leaf.x
 	rct.RelaxBboxUpwards(parent, point)
-	return RemoveIndex(rct.Leaves, index)
+	return RemoveIndex(rct.Leaves, index), nil
 }
 
 // UpdateLeafCountUpwards updates the stored count of leaves beneath each branch (branch.n)
-func (rct *RCTree) UpdateLeafCountUpwards(node *Node, inc int) {
+func (rct *Tree[K]) UpdateLeafCountUpwards(node *Node, inc int) {
 	for node != nil {
 		node.n += inc
 		node = node.u
@@ -244,9 +293,18 @@ func (rct *RCTree) UpdateLeafCountUpwards(node *Node, inc int) {
 }
 
 // InsertPoint inserts a point into the tree, creating a new leaf
-func (rct *RCTree) InsertPoint(point []float64, index int, tolerance float64) (*Node, error) {
+func (rct *Tree[K]) InsertPoint(point []float64, index K, tolerance float64) (*Node, error) {
+	order := rct.insertionOrder()
+	node, err := rct.insertPoint(point, index, tolerance)
+	if err == nil {
+		order.push(index)
+	}
+	return node, err
+}
+
+func (rct *Tree[K]) insertPoint(point []float64, index K, tolerance float64) (*Node, error) {
 	if rct.Root == nil {
-		leafNode := NewLeaf(index, 0, nil, point, 1)
+		leafNode := NewLeaf(leafIndex(index), nil, point, 1)
 		rct.Root = leafNode
 		rct.Ndim = len(point)
 		rct.Leaves[index] = leafNode
@@ -254,12 +312,12 @@ func (rct *RCTree) InsertPoint(point []float64, index int, tolerance float64) (*
 	}
 	// If leaves already exist in tree, check dimensions of point
 	if len(point) != rct.Ndim {
-		err := fmt.Errorf("Point dimension (%d) not equal to existing points in tree (%d)", len(point), rct.Ndim)
+		err := fmt.Errorf("%w: point (%d), tree (%d)", ErrDimensionMismatch, len(point), rct.Ndim)
 		return nil, err
 	}
 	// Check for existing index in leaves map
 	if _, exists := rct.Leaves[index]; exists {
-		err := fmt.Errorf("Index %d already exists in leaves map", index)
+		err := fmt.Errorf("%w: %v", ErrDuplicateIndex, index)
 		return nil, err
 	}
 	// Check for duplicate points
@@ -270,14 +328,6 @@ func (rct *RCTree) InsertPoint(point []float64, index int, tolerance float64) (*
 		return duplicate, nil
 	}
 	// Tree has points and point is not a duplicate, so continue
-	maxDepth := math.MinInt64
-	for _, node := range rct.Leaves {
-		if node.Leaf.d > maxDepth {
-			maxDepth = node.Leaf.d
-		}
-	}
-
-	depth := 0
 	var branchNode *Node
 	var leafNode *Node
 	var side string
@@ -285,20 +335,22 @@ func (rct *RCTree) InsertPoint(point []float64, index int, tolerance float64) (*
 	currentNode := rct.Root
 	parent := currentNode.u
 
-	for range make([]int, maxDepth+1) {
+	// Descend until a cut separates the point, which happens at a leaf at the latest
+	for {
 		bbox := currentNode.b
 		cutDimension, cut, _ := rct.InsertPointCut(point, bbox)
 
 		if cut <= bbox[0][cutDimension] {
-			leafNode = NewLeaf(index, depth, nil, point, 1)
+			leafNode = NewLeaf(leafIndex(index), nil, point, 1)
 			branchNode = NewBranch(cutDimension, cut, leafNode, currentNode, nil, leafNode.n+currentNode.n, nil)
 			break
 		} else if cut >= bbox[len(bbox)-1][cutDimension] {
-			leafNode = NewLeaf(index, depth, nil, point, 1)
+			leafNode = NewLeaf(leafIndex(index), nil, point, 1)
 			branchNode = NewBranch(cutDimension, cut, currentNode, leafNode, nil, leafNode.n+currentNode.n, nil)
 			break
+		} else if currentNode.isLeaf() {
+			break
 		} else {
-			depth++
 			parent = currentNode
 			if point[currentNode.Branch.q] <= currentNode.Branch.p {
 				currentNode = currentNode.Branch.l
@@ -310,7 +362,7 @@ func (rct *RCTree) InsertPoint(point []float64, index int, tolerance float64) (*
 		}
 	}
 	if branchNode == nil {
-		err := fmt.Errorf("A cut was not found for index %d", index)
+		err := fmt.Errorf("A cut was not found for index %v", index)
 		return nil, err
 	}
 
@@ -332,8 +384,6 @@ func (rct *RCTree) InsertPoint(point []float64, index int, tolerance float64) (*
 		// If a new root was created, assign the attribute
 		rct.Root = branchNode
 	}
-	// Increment depths below branch
-	rct.MapDepths(branchNode, 1)
 	// Increment leaf count above branch
 	rct.UpdateLeafCountUpwards(parent, 1)
 	// Update bounding boxes
@@ -345,7 +395,7 @@ func (rct *RCTree) InsertPoint(point []float64, index int, tolerance float64) (*
 }
 
 // Query searches for leaf nearest to point
-func (rct RCTree) Query(point []float64, node *Node) *Node {
+func (rct Tree[K]) Query(point []float64, node *Node) *Node {
 	if node == nil {
 		node = rct.Root
 	}
@@ -364,14 +414,16 @@ func queryPoint(point []float64, node *Node) *Node {
 }
 
 // Disp computes displacement at leaf
-func (rct RCTree) Disp(param interface{}) (int, error) {
+func (rct Tree[K]) Disp(param interface{}) (int, error) {
 	leaf, ok := param.(*Node)
 	if !ok {
-		index, ok := param.(int)
+		index, ok := param.(K)
 		if !ok {
-			return 0, fmt.Errorf("CoDisp parameter not recognised: %v", leaf)
+			return 0, fmt.Errorf("Disp parameter not recognised: %v", param)
+		}
+		if leaf, ok = rct.Leaves[index]; !ok {
+			return 0, fmt.Errorf("%w: %v", ErrNoSuchLeaf, index)
 		}
-		leaf = rct.Leaves[index]
 	}
 
 	// Handle case where leaf is root
@@ -390,18 +442,26 @@ func (rct RCTree) Disp(param interface{}) (int, error) {
 	return displacement, nil
 }
 
+// Depth returns the depth of a leaf, found by walking from the leaf to the root
+func (rct Tree[K]) Depth(index K) (int, error) {
+	leaf, err := rct.leafOf(index)
+	if err != nil {
+		return 0, err
+	}
+	return leaf.depth(), nil
+}
+
 // CoDisp computes collusive displacement (anomaly score) at leaf
-func (rct RCTree) CoDisp(param interface{}) (float64, error) {
+func (rct Tree[K]) CoDisp(param interface{}) (float64, error) {
 	leaf, ok := param.(*Node)
 	if !ok {
-		index, ok := param.(int)
+		index, ok := param.(K)
 		if !ok {
-			return 0, fmt.Errorf("CoDisp parameter not recognised: %v", leaf)
+			return 0, fmt.Errorf("CoDisp parameter not recognised: %v", param)
 		}
-		if _, ok := rct.Leaves[index]; !ok {
-			return 0, fmt.Errorf("No such leaf index: %d", index)
+		if leaf, ok = rct.Leaves[index]; !ok {
+			return 0, fmt.Errorf("%w: %v", ErrNoSuchLeaf, index)
 		}
-		leaf = rct.Leaves[index]
 	}
 
 	// Handle case where leaf is root
@@ -409,14 +469,10 @@ func (rct RCTree) CoDisp(param interface{}) (float64, error) {
 		return 0, nil
 	}
 	node := leaf
-	leafDepth := node.Leaf.d
 	var results []float64
 
-	for i := 0; i < leafDepth; i++ {
+	for !node.isRoot() {
 		parent := node.u
-		if parent == nil {
-			break
-		}
 		sibling := parent.Branch.l
 		if node == parent.Branch.l {
 			sibling = parent.Branch.r
@@ -431,8 +487,36 @@ func (rct RCTree) CoDisp(param interface{}) (float64, error) {
 	return coDisplacement, nil
 }
 
+// CoDispAll computes the collusive displacement of every leaf in one traversal,
+// keyed by index label
+// The largest displacement ratio over the ancestors of each node is carried down
+// from the root, so each node is visited once and duplicate points are scored once
+func (rct Tree[K]) CoDispAll() map[K]float64 {
+	scores := make(map[K]float64, len(rct.Leaves))
+	if rct.Root == nil {
+		return scores
+	}
+	leafScores := make(map[*Node]float64, len(rct.Leaves))
+	var descend func(node *Node, coDisplacement float64)
+	descend = func(node *Node, coDisplacement float64) {
+		if node.isLeaf() {
+			leafScores[node] = coDisplacement
+			return
+		}
+		left, right := node.Branch.l, node.Branch.r
+		descend(left, math.Max(coDisplacement, float64(right.n)/float64(left.n)))
+		descend(right, math.Max(coDisplacement, float64(left.n)/float64(right.n)))
+	}
+	descend(rct.Root, 0)
+
+	for index, leaf := range rct.Leaves {
+		scores[index] = leafScores[leaf]
+	}
+	return scores
+}
+
 // GetBbox computes the bounding box of all points underneath a given branch
-func (rct *RCTree) GetBbox(branch *Node) [][]float64 {
+func (rct *Tree[K]) GetBbox(branch *Node) [][]float64 {
 	if branch == nil {
 		branch = rct.Root
 	}
@@ -447,7 +531,7 @@ func (rct *RCTree) GetBbox(branch *Node) [][]float64 {
 
 // FindDuplicate returns the leaf containing the duplicate of an existing point in the tree
 // Returns nil if no duplicate found
-func (rct *RCTree) FindDuplicate(point []float64, tolerance float64) *Node {
+func (rct *Tree[K]) FindDuplicate(point []float64, tolerance float64) *Node {
 	nearest := rct.Query(point, nil)
 	if tolerance == 0 {
 		if array.CompareFloat(nearest.Leaf.x, point) {
@@ -494,7 +578,7 @@ func lrBranchBbox(branchNode *Node) [][]float64 {
 }
 
 // GetBboxTopDown recursively computes bboxes of all branches from root to leaves
-func (rct *RCTree) GetBboxTopDown(node *Node) {
+func (rct *Tree[K]) GetBboxTopDown(node *Node) {
 	if node.isBranch() {
 		if node.Branch.l != nil {
 			rct.GetBboxTopDown(node.Branch.l)
@@ -508,7 +592,7 @@ func (rct *RCTree) GetBboxTopDown(node *Node) {
 }
 
 // CountAllTopDown recursively computes the number of leaves below each branch from root to leaves
-func (rct *RCTree) CountAllTopDown(node *Node) {
+func (rct *Tree[K]) CountAllTopDown(node *Node) {
 	if node.isBranch() {
 		if node.Branch.l != nil {
 			rct.CountAllTopDown(node.Branch.l)
@@ -521,7 +605,7 @@ func (rct *RCTree) CountAllTopDown(node *Node) {
 }
 
 // CountLeaves counts the total leaves underneath a single node
-func (rct *RCTree) CountLeaves(branch *Node) int {
+func (rct *Tree[K]) CountLeaves(branch *Node) int {
 	var numLeaves int
 
 	rct.MapLeaves(branch, &numLeaves)
@@ -529,12 +613,12 @@ func (rct *RCTree) CountLeaves(branch *Node) int {
 }
 
 // SearchForLeaf -
-func (rct *RCTree) SearchForLeaf() {
+func (rct *Tree[K]) SearchForLeaf() {
 
 }
 
 // TightenBboxUpwards expands bbox of all nodes above new point if point is outside the existing bbox
-func (rct *RCTree) TightenBboxUpwards(node *Node) {
+func (rct *Tree[K]) TightenBboxUpwards(node *Node) {
 	bbox := lrBranchBbox(node)
 	node.b = bbox
 	node = node.u
@@ -561,7 +645,7 @@ func (rct *RCTree) TightenBboxUpwards(node *Node) {
 
 // RelaxBboxUpwards contracts bbox of all nodes above a deleted point
 // if the deleted point defined the boundary of the bbox
-func (rct *RCTree) RelaxBboxUpwards(node *Node, point []float64) {
+func (rct *Tree[K]) RelaxBboxUpwards(node *Node, point []float64) {
 	for node != nil {
 		bbox := lrBranchBbox(node)
 		lastIndex := len(node.b) - 1
@@ -577,7 +661,7 @@ func (rct *RCTree) RelaxBboxUpwards(node *Node, point []float64) {
 }
 
 // InsertPointCut generates the cut dimension and cut value based on InsertPoint()
-func (rct *RCTree) InsertPointCut(point []float64, bbox [][]float64) (int, float64, error) {
+func (rct *Tree[K]) InsertPointCut(point []float64, bbox [][]float64) (int, float64, error) {
 	// Generate the bounding box
 	bboxHat := array.Zero2D(len(bbox), len(bbox[0]))
 	// Update the bounding box based on the internal point
@@ -1,8 +1,11 @@
 package rrcf
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
+	"sort"
 	"testing"
 
 	"github.com/andysgithub/go-rrcf/array"
@@ -25,7 +28,7 @@ var (
 func TestInit(t *testing.T) {
 	n = 100
 	d = 3
-	rnd := random.NewRandomState(0)
+	rnd = random.NewRandomState(0)
 
 	X = rnd.Normal2D(n, d)
 	Z := array.DuplicateFloat(X)
@@ -72,6 +75,21 @@ func TestCoDisp(t *testing.T) {
 	}
 }
 
+func TestCoDispAll(t *testing.T) {
+	TestInit(t)
+
+	for _, rct := range []RCTree{tree, duplicateTree} {
+		scores := rct.CoDispAll()
+		assert.Len(t, scores, n)
+		for index := range rct.Leaves {
+			codisp, _ := rct.CoDisp(index)
+			assert.Equal(t, codisp, scores[index], fmt.Sprintf("Codisp of %d differs", index))
+		}
+	}
+	empty := NewRCTree(nil, nil, 9, nil)
+	assert.Empty(t, empty.CoDispAll())
+}
+
 func TestDisp(t *testing.T) {
 	TestInit(t)
 
@@ -86,7 +104,7 @@ func TestForgetBatch(t *testing.T) {
 
 	// Check stored bounding boxes and leaf counts after forgetting points
 	for _, index := range indexes {
-		forgotten := tree.ForgetPoint(index)
+		forgotten, _ := tree.ForgetPoint(index)
 		var branches []Node
 		branches = tree.MapBranches(tree.Root, branches)
 		for _, node := range branches {
@@ -132,6 +150,15 @@ func TestBatchWithDuplicates(t *testing.T) {
 		message := fmt.Sprintf("Leaf count %d in duplicate tree not equal to 10\n", duplicateTree.Leaves[i].n)
 		assert.Equal(t, duplicateTree.Leaves[i].n, 10, message)
 	}
+
+	// A batch of a single distinct point forms a single leaf
+	constant := NewRCTree([][]float64{{5}, {5}, {5}}, nil, 9, 0)
+	assert.True(t, constant.Root.isLeaf(), "Constant batch not held in one leaf")
+	assert.Equal(t, 3, constant.Root.n, "Wrong count for constant batch")
+	assert.Equal(t, []int{0, 1, 2}, constant.InsertionOrder())
+	single := NewRCTree([][]float64{{1, 2}}, nil, 9, 0)
+	assert.Equal(t, 2, single.Ndim, "Wrong dimension for single point")
+	assert.Equal(t, 1, len(single.Leaves), "Single point not held")
 }
 
 func TestInsertDuplicate(t *testing.T) {
@@ -185,9 +212,258 @@ func TestInsertDepth(t *testing.T) {
 
 	minDepth := math.MaxInt64
 	for _, node := range tree.Leaves {
-		if node.Leaf.d < minDepth {
-			minDepth = node.Leaf.d
+		if node.depth() < minDepth {
+			minDepth = node.depth()
 		}
 	}
 	assert.GreaterOrEqual(t, minDepth, 0)
 }
+
+func TestLeafDepth(t *testing.T) {
+	rnd := random.NewRandomState(3)
+	points := rnd.Normal2D(200, 2)
+	tree := NewRCTree(points[:50], nil, 9, 1)
+	for index, point := range points[50:] {
+		_, err := tree.InsertPoint(point, index+50, 0)
+		assert.NoError(t, err)
+		_, err = tree.ForgetPoint(index)
+		assert.NoError(t, err)
+	}
+
+	// Depths found on demand match the depths of the leaves found from the root
+	depths := make(map[int]int)
+	var collect func(obj *NodeObject, depth int)
+	collect = func(obj *NodeObject, depth int) {
+		if obj.Type == "Branch" {
+			collect(obj.L, depth+1)
+			collect(obj.R, depth+1)
+			return
+		}
+		assert.Equal(t, depth, obj.D)
+		for _, index := range obj.Ixs {
+			depths[index] = depth
+		}
+	}
+	collect(tree.ToDict(), 0)
+	assert.Len(t, depths, 50)
+	for index, depth := range depths {
+		leafDepth, err := tree.Depth(index)
+		assert.NoError(t, err)
+		assert.Equal(t, depth, leafDepth, fmt.Sprintf("Wrong depth for leaf %d", index))
+	}
+	_, err := tree.Depth(0)
+	assert.True(t, errors.Is(err, ErrNoSuchLeaf))
+}
+
+func TestMissingLeaf(t *testing.T) {
+	TestInit(t)
+
+	_, err := tree.ForgetPoint(n)
+	assert.True(t, errors.Is(err, ErrNoSuchLeaf), "Forgot missing leaf: %v", err)
+	_, err = tree.Disp(n)
+	assert.True(t, errors.Is(err, ErrNoSuchLeaf), "Disp of missing leaf: %v", err)
+	_, err = tree.CoDisp(n)
+	assert.True(t, errors.Is(err, ErrNoSuchLeaf), "CoDisp of missing leaf: %v", err)
+	_, err = tree.InsertPoint([]float64{0, 0}, n, 0)
+	assert.True(t, errors.Is(err, ErrDimensionMismatch), "Inserted point of wrong dimension: %v", err)
+	_, err = tree.InsertPoint([]float64{0, 0, 0}, 0, 0)
+	assert.True(t, errors.Is(err, ErrDuplicateIndex), "Inserted duplicate index: %v", err)
+}
+
+func TestExpectedCoDisp(t *testing.T) {
+	rnd := random.NewRandomState(1)
+	points := rnd.Normal2D(50, 3)
+	base := NewRCTree(points, nil, 9, 3)
+	state := base.State()
+
+	for _, point := range [][]float64{{0.1, -0.2, 0.3}, {4, 4, 4}, points[7]} {
+		expected, err := base.ExpectedCoDisp(point, 0)
+		assert.NoError(t, err)
+
+		// Average the codisp over actual insertions into copies of the tree
+		trials := 4000
+		var mean float64
+		for trial := 0; trial < trials; trial++ {
+			trialTree, _ := NewRCTreeFromState(state)
+			trialTree.Rng = random.NewRandomState(int64(trial))
+			trialTree.InsertPoint(point, 50, 0)
+			codisp, _ := trialTree.CoDisp(50)
+			mean += codisp / float64(trials)
+		}
+		assert.InDelta(t, mean, expected, 0.05*mean+0.05, fmt.Sprintf("Expected codisp differs for %v", point))
+	}
+	assert.Equal(t, state, base.State(), "Tree changed by expected codisp")
+
+	_, err := base.ExpectedCoDisp([]float64{0, 0}, 0)
+	assert.True(t, errors.Is(err, ErrDimensionMismatch), "Scored point of wrong dimension: %v", err)
+}
+
+func TestAttribution(t *testing.T) {
+	rnd := random.NewRandomState(2)
+	points := rnd.Normal2D(60, 3)
+	// Make one point stand out in the second dimension only
+	points[5][1] = 8
+	tree := NewRCTree(points, nil, 9, 4)
+
+	for index := range tree.Leaves {
+		codisp, _ := tree.CoDisp(index)
+		attribution, err := tree.Attribution(index)
+		assert.NoError(t, err)
+
+		var total float64
+		for _, value := range attribution {
+			total += value
+		}
+		assert.InDelta(t, codisp, total, 1e-9, fmt.Sprintf("Attribution of %d does not sum to codisp", index))
+	}
+
+	attribution, _ := tree.Attribution(5)
+	assert.Greater(t, attribution[1], attribution[0], "Anomalous dimension not attributed")
+	assert.Greater(t, attribution[1], attribution[2], "Anomalous dimension not attributed")
+
+	_, err := tree.Attribution(60)
+	assert.True(t, errors.Is(err, ErrNoSuchLeaf), "Attribution of missing leaf: %v", err)
+}
+
+func TestAlternativeScores(t *testing.T) {
+	rnd := random.NewRandomState(2)
+	points := rnd.Normal2D(60, 3)
+	points[5][1] = 8
+	tree := NewRCTree(points, nil, 9, 4)
+
+	var isolation []float64
+	for index := range tree.Leaves {
+		score, err := tree.IsolationScore(index)
+		assert.NoError(t, err)
+		assert.Greater(t, score, 0.0)
+		assert.LessOrEqual(t, score, 1.0)
+		isolation = append(isolation, score)
+
+		mass, _ := tree.MassScore(index)
+		assert.GreaterOrEqual(t, mass*60, 2.0, "Parent holds fewer than two points")
+
+		// The best level gives the codisp, and a mass cutoff can only lower it
+		codisp, _ := tree.CoDisp(index)
+		minMass, _ := tree.MinMassCoDisp(index, 0)
+		assert.Equal(t, codisp, minMass)
+		cutoff, _ := tree.MinMassCoDisp(index, 20)
+		assert.LessOrEqual(t, cutoff, codisp)
+		none, _ := tree.MinMassCoDisp(index, 61)
+		assert.Equal(t, 0.0, none)
+		disp, _ := tree.BestDisp(index)
+		assert.GreaterOrEqual(t, float64(disp), codisp)
+		assert.Less(t, disp, 60)
+	}
+	sort.Float64s(isolation)
+	outlier, _ := tree.IsolationScore(5)
+	assert.Greater(t, outlier, isolation[len(isolation)/2], "Outlier not isolated early")
+	assert.InDelta(t, 0.5, isolation[len(isolation)/2], 0.15, "Median isolation score not near 0.5")
+
+	_, err := tree.MassScore(60)
+	assert.True(t, errors.Is(err, ErrNoSuchLeaf), "Mass score of missing leaf: %v", err)
+}
+
+func TestImpute(t *testing.T) {
+	// Points on the line y = 2x, z = -x
+	var points [][]float64
+	for i := 0; i < 50; i++ {
+		x := float64(i) / 10
+		points = append(points, []float64{x, 2 * x, -x})
+	}
+	tree := NewRCTree(points, nil, 9, 4)
+
+	imputed, err := tree.Impute([]float64{2.5, math.NaN(), math.NaN()})
+	assert.NoError(t, err)
+	assert.Equal(t, 2.5, imputed[0], "Known value changed")
+	assert.InDelta(t, 5, imputed[1], 0.5, "Missing value not imputed from neighbours")
+	assert.InDelta(t, -2.5, imputed[2], 0.5, "Missing value not imputed from neighbours")
+
+	imputed, _ = tree.Impute([]float64{math.NaN(), math.NaN(), math.NaN()})
+	assert.InDelta(t, 2.45, imputed[0], 1e-9, "Fully missing point not imputed as the mean")
+
+	_, err = tree.Impute([]float64{1, math.NaN()})
+	assert.True(t, errors.Is(err, ErrDimensionMismatch), "Imputed point of wrong dimension: %v", err)
+	empty := NewRCTree(nil, nil, 0, 0)
+	_, err = empty.Impute([]float64{math.NaN()})
+	assert.True(t, errors.Is(err, ErrEmptyTree), "Imputed from empty tree: %v", err)
+}
+
+func TestInsertionOrder(t *testing.T) {
+	tree := NewRCTree([][]float64{{0, 0, 0}, {1, 1, 1}, {2, 0, 1}}, []int{30, 10, 20}, 9, 0)
+	assert.Equal(t, []int{30, 10, 20}, tree.InsertionOrder(), "Batch order not kept")
+
+	tree.InsertPoint([]float64{3, 3, 3}, -5, 0)
+	tree.InsertPoint([]float64{1, 1, 1}, 7, 0)
+	tree.ForgetPoint(10)
+	assert.Equal(t, []int{30, 20, -5, 7}, tree.InsertionOrder())
+
+	// A forgotten label inserted again is the newest
+	tree.ForgetPoint(30)
+	tree.InsertPoint([]float64{4, 4, 4}, 30, 0)
+	oldest, ok := tree.Oldest()
+	assert.True(t, ok)
+	assert.Equal(t, 20, oldest, "Wrong oldest point")
+
+	for _, expected := range []int{20, -5, 7, 30} {
+		index, err := tree.ForgetOldest()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, index, "Points not forgotten in insertion order")
+	}
+	_, err := tree.ForgetOldest()
+	assert.True(t, errors.Is(err, ErrEmptyTree), "Forgot from empty tree: %v", err)
+
+	// The order survives saving and loading, and long streams stay compact
+	for index := 0; index < 1000; index++ {
+		tree.InsertPoint([]float64{float64(index), 0}, index, 0)
+		if index >= 10 {
+			tree.ForgetOldest()
+		}
+	}
+	restored, _ := NewRCTreeFromState(tree.State())
+	assert.Equal(t, tree.InsertionOrder(), restored.InsertionOrder(), "Order not restored")
+	assert.LessOrEqual(t, len(tree.order.queue), 64, "Order queue not compacted")
+}
+
+func TestLabeledTree(t *testing.T) {
+	_, err := NewTree([][]float64{{0, 0}, {1, 1}}, []string{"a"}, 9, 0)
+	assert.Error(t, err, "Missing labels not reported")
+
+	labeled, err := NewTree([][]float64{{0, 0}, {1, 1}, {1, 1}}, []string{"a", "b", "c"}, 9, 0)
+	assert.NoError(t, err)
+	_, err = labeled.InsertPoint([]float64{8, 9}, "far", 0)
+	assert.NoError(t, err)
+	_, err = labeled.InsertPoint([]float64{8, 9}, "far", 0)
+	assert.True(t, errors.Is(err, ErrDuplicateIndex), "Duplicate label inserted: %v", err)
+	assert.Equal(t, labeled.Leaves["b"], labeled.Leaves["c"], "Duplicate points not sharing a leaf")
+
+	codisp, err := labeled.CoDisp("far")
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, codisp, 2.0, "Outlying label scored too low")
+	disp, err := labeled.Disp("far")
+	assert.NoError(t, err)
+	assert.Contains(t, []int{1, 2, 3}, disp)
+	_, err = labeled.CoDisp(0)
+	assert.Error(t, err, "Integer accepted as a string label")
+
+	_, err = labeled.ForgetPoint("a")
+	assert.NoError(t, err)
+	_, err = labeled.ForgetPoint("a")
+	assert.True(t, errors.Is(err, ErrNoSuchLeaf), "Forgot missing label: %v", err)
+	assert.Equal(t, []string{"b", "c", "far"}, labeled.InsertionOrder())
+
+	// Labels are kept when saving and loading
+	data, err := json.Marshal(labeled)
+	assert.NoError(t, err)
+	restored := newTree[string](nil)
+	assert.NoError(t, json.Unmarshal(data, &restored))
+	assert.Equal(t, labeled.InsertionOrder(), restored.InsertionOrder())
+	codisp, _ = labeled.CoDisp("far")
+	restoredCoDisp, _ := restored.CoDisp("far")
+	assert.Equal(t, codisp, restoredCoDisp, "Score changed by saving and loading")
+
+	obj := labeled.ToDict()
+	fromDict := newTree[string](nil)
+	assert.NoError(t, fromDict.LoadDict(obj))
+	assert.Len(t, fromDict.Leaves, 3)
+	assert.Equal(t, fromDict.Leaves["b"], fromDict.Leaves["c"], "Duplicates not restored from dict")
+}
@@ -0,0 +1,240 @@
+package rrcf
+
+import (
+	"fmt"
+	"math"
+)
+
+// ExpectedCoDisp computes the expected collusive displacement a point would receive
+// if it were inserted into the tree, without changing the tree or its random state
+//
+// Insertion places the point beside the first node on its path whose bounding box,
+// once extended to include the point, is cut so as to separate it. The chance of this
+// at each node is the proportion of the extended bounding box lying outside the
+// node's own bounding box. The codisp the point would have at each possible position
+// is weighted by the chance of it being inserted there.
+func (rct Tree[K]) ExpectedCoDisp(point []float64, tolerance float64) (float64, error) {
+	if rct.Root == nil {
+		return 0, nil
+	}
+	if len(point) != rct.Ndim {
+		return 0, fmt.Errorf("%w: point (%d), tree (%d)", ErrDimensionMismatch, len(point), rct.Ndim)
+	}
+
+	// A duplicate point joins the existing leaf
+	duplicate := rct.FindDuplicate(point, tolerance)
+	if duplicate != nil {
+		return duplicateCoDisp(duplicate), nil
+	}
+
+	var expected float64
+	// Chance of the insertion reaching the current node
+	reach := 1.0
+	// Maximum displacement ratio over the ancestors of the current node
+	ancestorMax := math.Inf(-1)
+
+	node := rct.Root
+	for node != nil && reach > 0 {
+		separation := separationProbability(point, node.b)
+		// If separated here, the new leaf takes the place of the node and displaces it
+		codisp := math.Max(float64(node.n), ancestorMax)
+		expected += reach * separation * codisp
+		reach *= 1 - separation
+
+		if node.isLeaf() {
+			break
+		}
+		next, sibling := node.Branch.l, node.Branch.r
+		if point[node.Branch.q] > node.Branch.p {
+			next, sibling = sibling, next
+		}
+		// The new point would add one to the count of each node on its path
+		ancestorMax = math.Max(ancestorMax, float64(sibling.n)/float64(next.n+1))
+		node = next
+	}
+	return expected, nil
+}
+
+// duplicateCoDisp computes the codisp for a further point added to an existing leaf
+func duplicateCoDisp(leaf *Node) float64 {
+	coDisplacement := 0.0
+	for node := leaf; !node.isRoot(); node = node.u {
+		parent := node.u
+		sibling := parent.Branch.l
+		if node == parent.Branch.l {
+			sibling = parent.Branch.r
+		}
+		coDisplacement = math.Max(coDisplacement, float64(sibling.n)/float64(node.n+1))
+	}
+	return coDisplacement
+}
+
+// separationProbability returns the chance that a random cut of the bounding box,
+// extended to include the point, separates the point from the bounding box
+func separationProbability(point []float64, bbox [][]float64) float64 {
+	mins := bbox[0]
+	maxes := bbox[len(bbox)-1]
+
+	var span, extendedSpan float64
+	for dim, value := range point {
+		span += maxes[dim] - mins[dim]
+		extendedSpan += math.Max(maxes[dim], value) - math.Min(mins[dim], value)
+	}
+	if extendedSpan == 0 {
+		return 0
+	}
+	return (extendedSpan - span) / extendedSpan
+}
+
+// Attribution splits the collusive displacement of a leaf across the dimensions of the tree
+//
+// Each branch on the path from the leaf to the root cuts dimension q at value p,
+// separating the leaf's side of the branch from its sibling. The codisp is shared
+// between the cut dimensions in proportion to the displacement of each cut, so
+// dimensions whose cuts isolate the leaf from many other points receive the most.
+// The contributions sum to the codisp of the leaf.
+func (rct Tree[K]) Attribution(index K) ([]float64, error) {
+	leaf, ok := rct.Leaves[index]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNoSuchLeaf, index)
+	}
+	attribution := make([]float64, rct.Ndim)
+	if leaf.isRoot() {
+		return attribution, nil
+	}
+
+	node := leaf
+	var coDisplacement, totalDisplacement float64
+	for !node.isRoot() {
+		parent := node.u
+		sibling := parent.Branch.l
+		if node == parent.Branch.l {
+			sibling = parent.Branch.r
+		}
+		displacement := float64(sibling.n) / float64(node.n)
+		attribution[parent.Branch.q] += displacement
+		totalDisplacement += displacement
+		coDisplacement = math.Max(coDisplacement, displacement)
+		node = parent
+	}
+
+	if totalDisplacement > 0 {
+		for dim := range attribution {
+			attribution[dim] *= coDisplacement / totalDisplacement
+		}
+	}
+	return attribution, nil
+}
+
+// eulerGamma is the Euler-Mascheroni constant, used to approximate harmonic numbers
+const eulerGamma = 0.5772156649015329
+
+// averagePathLength returns the average depth of an unsuccessful search in a binary
+// search tree of n points, which normalizes isolation depths as in isolation forest
+func averagePathLength(n int) float64 {
+	if n <= 1 {
+		return 0
+	}
+	if n == 2 {
+		return 1
+	}
+	return 2*(math.Log(float64(n-1))+eulerGamma) - 2*float64(n-1)/float64(n)
+}
+
+// leafOf returns the leaf with the given index
+func (rct Tree[K]) leafOf(index K) (*Node, error) {
+	leaf, ok := rct.Leaves[index]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNoSuchLeaf, index)
+	}
+	return leaf, nil
+}
+
+// ancestors calls visit at each level on the path from a leaf to the root, with the
+// node on the path and its sibling under their parent
+func ancestors(leaf *Node, visit func(node, sibling *Node)) {
+	for node := leaf; !node.isRoot(); node = node.u {
+		parent := node.u
+		sibling := parent.Branch.l
+		if node == parent.Branch.l {
+			sibling = parent.Branch.r
+		}
+		visit(node, sibling)
+	}
+}
+
+// IsolationScore computes the isolation forest anomaly score of a leaf
+//
+// The depth of the leaf, extended by the average depth of a search among any
+// duplicate points it holds, is normalized by the average path length for the
+// points in the tree. The score is 2^(-depth/average), which is near 1 for points
+// isolated close to the root, 0.5 for points at the average depth and near 0 for
+// points deep in the tree.
+func (rct Tree[K]) IsolationScore(index K) (float64, error) {
+	leaf, err := rct.leafOf(index)
+	if err != nil {
+		return 0, err
+	}
+	average := averagePathLength(rct.Root.n)
+	if leaf.isRoot() || average == 0 {
+		return 0, nil
+	}
+	depth := float64(leaf.depth()) + averagePathLength(leaf.n)
+	return math.Pow(2, -depth/average), nil
+}
+
+// MassScore computes the relative mass anomaly score of a leaf
+//
+// The mass of the leaf's parent, the smallest region holding the leaf and any other
+// point, is compared with the mass of the leaf and scaled by the number of points in
+// the tree, as in ReMass isolation forest. A leaf set apart from a large mass of
+// points scores highly, whatever its depth.
+func (rct Tree[K]) MassScore(index K) (float64, error) {
+	leaf, err := rct.leafOf(index)
+	if err != nil {
+		return 0, err
+	}
+	if leaf.isRoot() {
+		return 0, nil
+	}
+	return float64(leaf.u.n) / float64(leaf.n*rct.Root.n), nil
+}
+
+// BestDisp computes the displacement of a leaf at the level giving its collusive
+// displacement
+// The displacement is the number of points removed from the path with the subtree
+// holding the leaf, at the ancestor where this is largest relative to the subtree
+func (rct Tree[K]) BestDisp(index K) (int, error) {
+	leaf, err := rct.leafOf(index)
+	if err != nil {
+		return 0, err
+	}
+	var displacement int
+	bestRatio := -1.0
+	ancestors(leaf, func(node, sibling *Node) {
+		if ratio := float64(sibling.n) / float64(node.n); ratio > bestRatio {
+			bestRatio = ratio
+			displacement = sibling.n
+		}
+	})
+	return displacement, nil
+}
+
+// MinMassCoDisp computes the collusive displacement of a leaf, ignoring ancestors
+// holding fewer than minMass points
+// Small subtrees give high displacement ratios to points in small clusters, so
+// a mass cutoff limits the codisp to displacement from larger groups of points
+func (rct Tree[K]) MinMassCoDisp(index K, minMass int) (float64, error) {
+	leaf, err := rct.leafOf(index)
+	if err != nil {
+		return 0, err
+	}
+	var coDisplacement float64
+	ancestors(leaf, func(node, sibling *Node) {
+		if node.u.n < minMass {
+			return
+		}
+		coDisplacement = math.Max(coDisplacement, float64(sibling.n)/float64(node.n))
+	})
+	return coDisplacement, nil
+}
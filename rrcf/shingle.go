@@ -0,0 +1,28 @@
+package rrcf
+
+import (
+	"github.com/andysgithub/go-rrcf/array"
+)
+
+// Shingle steps through overlapping windows of consecutive points in a sequence
+// It matches the generator produced by rrcf.shingle() in the Python rrcf library
+type Shingle struct {
+	sequence [][]float64 // Points of the sequence
+	size     int         // Number of points in each window
+	position int         // Position in the sequence of the next window
+}
+
+// NewShingle returns a shingle of the given size over the points in X
+func NewShingle(X [][]float64, size int) *Shingle {
+	return &Shingle{sequence: X, size: size}
+}
+
+// Next returns a copy of the next window of points, or nil once the sequence is exhausted
+func (shingle *Shingle) Next() [][]float64 {
+	if shingle.size <= 0 || shingle.position+shingle.size > len(shingle.sequence) {
+		return nil
+	}
+	window := array.DuplicateFloat(shingle.sequence[shingle.position : shingle.position+shingle.size])
+	shingle.position++
+	return window
+}
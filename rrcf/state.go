@@ -0,0 +1,226 @@
+package rrcf
+
+import (
+	"fmt"
+
+	"github.com/andysgithub/go-rrcf/array"
+	"github.com/andysgithub/go-rrcf/random"
+)
+
+// StateVersion is the version of the tree state format written by State
+const StateVersion = 1
+
+// TreeStateOf is the serializable state of a Tree with index labels of type K
+//
+// Nodes are stored in a flat array in pre-order, so the root (if any) is node 0
+// and every child appears after its parent. Branches refer to their children by
+// position in the array, and Leaves maps each index label to the position of its
+// leaf, so duplicate points share a single leaf node. Order lists the index labels
+// in the order they were inserted, oldest first. The state of the random number
+// generator is recorded, so that a restored tree continues with the same random
+// cuts as the original.
+//
+// Encoding the state as json requires labels that are integers, strings or
+// implement encoding.TextMarshaler, as for json map keys.
+type TreeStateOf[K comparable] struct {
+	Version     int         `json:"version"`      // Version of the state format
+	Ndim        int         `json:"ndim"`         // Dimension of points in the tree
+	IndexLabels []K         `json:"index_labels"` // Index labels used to build the tree
+	Rng         []byte      `json:"rng"`          // State of the random number generator
+	Root        int         `json:"root"`         // Position of the root node, or -1 for an empty tree
+	Nodes       []NodeState `json:"nodes"`        // All nodes of the tree in pre-order
+	Leaves      map[K]int   `json:"leaves"`       // Position of the leaf for each index label
+	Order       []K         `json:"order"`        // Index labels in insertion order, oldest first
+}
+
+// TreeState is the serializable state of an RCTree
+type TreeState = TreeStateOf[int]
+
+// NodeState is the serializable state of a leaf or branch
+type NodeState struct {
+	Type string      `json:"type"`        // Type of node - 'leaf' or 'branch'
+	N    int         `json:"n"`           // Number of leaves under branch or points in leaf
+	Q    int         `json:"q,omitempty"` // Dimension of cut
+	P    float64     `json:"p"`           // Value of cut
+	L    int         `json:"l,omitempty"` // Position of left child
+	R    int         `json:"r,omitempty"` // Position of right child
+	B    [][]float64 `json:"b,omitempty"` // Bounding box of points under branch
+	I    int         `json:"i,omitempty"` // Index of leaf
+	D    int         `json:"d,omitempty"` // Depth of leaf
+	X    []float64   `json:"x,omitempty"` // Original point
+}
+
+// State returns the complete state of the tree in serializable form
+func (rct Tree[K]) State() TreeStateOf[K] {
+	state := TreeStateOf[K]{
+		Version:     StateVersion,
+		Ndim:        rct.Ndim,
+		IndexLabels: rct.IndexLabels,
+		Root:        -1,
+		Leaves:      make(map[K]int),
+	}
+	if rct.Rng != nil {
+		state.Rng = rct.Rng.State()
+	}
+
+	positions := make(map[*Node]int)
+	if rct.Root != nil {
+		state.Root = state.addNode(rct.Root, positions, 0)
+	}
+	for index, leaf := range rct.Leaves {
+		state.Leaves[index] = positions[leaf]
+	}
+	state.Order = rct.InsertionOrder()
+	return state
+}
+
+// addNode recursively appends a node at the given depth and its children in pre-order,
+// returning its position
+func (state *TreeStateOf[K]) addNode(node *Node, positions map[*Node]int, depth int) int {
+	position := len(state.Nodes)
+	positions[node] = position
+	state.Nodes = append(state.Nodes, NodeState{})
+
+	nodeState := NodeState{N: node.n}
+	if node.isLeaf() {
+		nodeState.Type = "leaf"
+		nodeState.I = node.Leaf.I
+		nodeState.D = depth
+		nodeState.X = node.Leaf.x
+	} else {
+		nodeState.Type = "branch"
+		nodeState.Q = node.Branch.q
+		nodeState.P = node.Branch.p
+		nodeState.B = node.b
+		nodeState.L = state.addNode(node.Branch.l, positions, depth+1)
+		nodeState.R = state.addNode(node.Branch.r, positions, depth+1)
+	}
+	state.Nodes[position] = nodeState
+	return position
+}
+
+// NewRCTreeFromState rebuilds a tree from the state returned by State
+func NewRCTreeFromState(state TreeState) (RCTree, error) {
+	return NewTreeFromState(state)
+}
+
+// NewTreeFromState rebuilds a tree with index labels of any comparable type
+// from the state returned by State
+func NewTreeFromState[K comparable](state TreeStateOf[K]) (Tree[K], error) {
+	rct := Tree[K]{
+		make(map[K]*Node),
+		nil, state.Ndim, nil, nil, nil, nil,
+	}
+	if state.Version != StateVersion {
+		return rct, fmt.Errorf("Unsupported tree state version: %d", state.Version)
+	}
+	rng, err := random.RestoreRandomState(state.Rng)
+	if err != nil {
+		return rct, fmt.Errorf("Invalid random number generator state: %w", err)
+	}
+	rct.Rng = rng
+	if state.IndexLabels != nil {
+		rct.IndexLabels = append([]K{}, state.IndexLabels...)
+	}
+
+	if state.Root == -1 {
+		if len(state.Nodes) > 0 || len(state.Leaves) > 0 {
+			return rct, fmt.Errorf("Empty tree state contains %d nodes", len(state.Nodes))
+		}
+		return rct, nil
+	}
+	if state.Root != 0 || len(state.Nodes) == 0 {
+		return rct, fmt.Errorf("Root position %d not valid for %d nodes", state.Root, len(state.Nodes))
+	}
+
+	nodes := make([]*Node, len(state.Nodes))
+	// Create nodes in reverse pre-order, so that children exist before their parents
+	for position := len(state.Nodes) - 1; position >= 0; position-- {
+		nodeState := state.Nodes[position]
+		switch nodeState.Type {
+		case "leaf":
+			if len(nodeState.X) != state.Ndim {
+				return rct, fmt.Errorf("Leaf at position %d has dimension %d, expected %d", position, len(nodeState.X), state.Ndim)
+			}
+			if nodeState.N < 1 {
+				return rct, fmt.Errorf("Leaf at position %d has invalid count %d", position, nodeState.N)
+			}
+			x := append([]float64{}, nodeState.X...)
+			nodes[position] = NewLeaf(nodeState.I, nil, x, nodeState.N)
+		case "branch":
+			for _, child := range []int{nodeState.L, nodeState.R} {
+				if child <= position || child >= len(nodes) || nodes[child].u != nil {
+					return rct, fmt.Errorf("Branch at position %d has invalid child %d", position, child)
+				}
+			}
+			if len(nodeState.B) != 2 || len(nodeState.B[0]) != state.Ndim || len(nodeState.B[1]) != state.Ndim {
+				return rct, fmt.Errorf("Branch at position %d has invalid bounding box", position)
+			}
+			if nodeState.Q < 0 || nodeState.Q >= state.Ndim {
+				return rct, fmt.Errorf("Branch at position %d has invalid cut dimension %d", position, nodeState.Q)
+			}
+			bbox := array.VStack(
+				append([]float64{}, nodeState.B[0]...),
+				append([]float64{}, nodeState.B[1]...))
+			l := nodes[nodeState.L]
+			r := nodes[nodeState.R]
+			if nodeState.N != l.n+r.n {
+				return rct, fmt.Errorf("Branch at position %d has count %d, expected %d", position, nodeState.N, l.n+r.n)
+			}
+			branch := NewBranch(nodeState.Q, nodeState.P, l, r, nil, nodeState.N, bbox)
+			l.u = branch
+			r.u = branch
+			nodes[position] = branch
+		default:
+			return rct, fmt.Errorf("Node at position %d has unknown type: %q", position, nodeState.Type)
+		}
+	}
+	// Every node other than the root must be the child of a branch, and every leaf
+	// must be at its recorded depth
+	depths := make([]int, len(nodes))
+	for position, node := range nodes {
+		if position > 0 && node.u == nil {
+			return rct, fmt.Errorf("Node at position %d is not linked to the tree", position)
+		}
+		nodeState := state.Nodes[position]
+		if node.isBranch() {
+			depths[nodeState.L] = depths[position] + 1
+			depths[nodeState.R] = depths[position] + 1
+		} else if nodeState.D != depths[position] {
+			return rct, fmt.Errorf("Leaf at position %d has depth %d, expected %d", position, nodeState.D, depths[position])
+		}
+	}
+	rct.Root = nodes[0]
+
+	// Every leaf must hold as many points as the index labels referring to it
+	counts := make(map[*Node]int)
+	for index, position := range state.Leaves {
+		if position < 0 || position >= len(nodes) || !nodes[position].isLeaf() {
+			return rct, fmt.Errorf("Index %v does not refer to a leaf", index)
+		}
+		rct.Leaves[index] = nodes[position]
+		counts[nodes[position]]++
+	}
+	for position, node := range nodes {
+		if node.isLeaf() && counts[node] != node.n {
+			return rct, fmt.Errorf("Leaf at position %d has count %d for %d index labels", position, node.n, counts[node])
+		}
+	}
+
+	// States without an insertion order are taken to be in ascending label order
+	if state.Order != nil {
+		if len(state.Order) != len(rct.Leaves) {
+			return rct, fmt.Errorf("Insertion order has %d labels for %d leaves", len(state.Order), len(rct.Leaves))
+		}
+		rct.order = newInsertionOrder(state.Order)
+		for _, index := range state.Order {
+			if _, ok := rct.Leaves[index]; !ok {
+				return rct, fmt.Errorf("Insertion order label %v does not refer to a leaf", index)
+			}
+		}
+		if len(rct.order.inserted) != len(rct.Leaves) {
+			return rct, fmt.Errorf("Insertion order contains repeated labels")
+		}
+	}
+	return rct, nil
+}
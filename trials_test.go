@@ -1,9 +1,13 @@
 package main
 
 import (
+	"math"
+	"path/filepath"
 	"testing"
 
+	"github.com/andysgithub/go-rrcf/forest"
 	"github.com/andysgithub/go-rrcf/utils"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestTrials(t *testing.T) {
@@ -17,16 +21,53 @@ func TestTrials(t *testing.T) {
 	utils.WriteToCsv(plotPoints, "results/training/plot_points.csv")
 }
 
+func TestWarmStartTrial(t *testing.T) {
+	plotPoints := WarmStartTrial()
+	assert.Len(t, plotPoints, 730)
+	err := utils.WriteToCsv(plotPoints, filepath.Join(t.TempDir(), "plot_points.csv"))
+	assert.NoError(t, err)
+}
+
+func TestForecastTrial(t *testing.T) {
+	plotPoints := ForecastTrial()
+	assert.Len(t, plotPoints, 730)
+	err := utils.WriteToCsv(plotPoints, filepath.Join(t.TempDir(), "forecast_points.csv"))
+	assert.NoError(t, err)
+
+	// The values from 235 to 255 are held at 80, as an anomaly in the sine wave
+	outside := func(plotPoint []float64) bool {
+		return plotPoint[0] < plotPoint[2] || plotPoint[0] > plotPoint[3]
+	}
+	assert.True(t, outside(plotPoints[235]), "Start of anomaly within expected band")
+	assert.True(t, outside(plotPoints[256]), "End of anomaly within expected band")
+
+	// Elsewhere the data was not seen in training, and is forecast closely
+	var totalError float64
+	var count, covered int
+	for sampleIndex, plotPoint := range plotPoints {
+		if sampleIndex >= 235 && sampleIndex < 260 {
+			continue
+		}
+		totalError += math.Abs(plotPoint[0] - plotPoint[1])
+		count++
+		if !outside(plotPoint) {
+			covered++
+		}
+	}
+	assert.Less(t, totalError/float64(count), 1.0, "Forecasts not within 1% of the range of the data")
+	assert.Greater(t, float64(covered)/float64(count), 0.9, "Expected band covers too few values")
+}
+
 // BatchTrial shows how the algorithm can be used to detect outliers in a batch setting
 func BatchTrial() [][]float64 {
 	// Get random 3D data with anomalies
 	points, _ := utils.ReadFromCsv("data/random3D.csv")
 
 	// Construct a random forest
-	token := InitForest(100, 256, points, 0)
+	token, _ := InitForest(100, 256, points, 0)
 
 	// Compute average anomaly score
-	scores := ScoreForest(token)
+	scores, _ := ScoreForest(token)
 
 	// Calculate the threshold for the 99.5th percentile
 	threshold := utils.GetThreshold(scores, 99.5)
@@ -41,7 +82,7 @@ func StreamingTrial() [][]float64 {
 	points, _ := utils.ReadFromCsv("data/sine.csv")
 
 	// Construct a forest of empty trees
-	token := InitForest(40, 256, nil, 3)
+	token, _ := InitForest(40, 256, nil, 3)
 
 	// Create a map to store the anomaly score of each point
 	scores := make(map[int]float64)
@@ -49,7 +90,7 @@ func StreamingTrial() [][]float64 {
 	// For each streamed data point
 	for sampleIndex, point := range points {
 		// Update the forest with this point and record the average score
-		scores[sampleIndex] = UpdateForest(token, sampleIndex, point)
+		scores[sampleIndex], _ = UpdateForest(token, sampleIndex, point)
 	}
 
 	// Return points for plotting
@@ -62,7 +103,7 @@ func TrainingTrial() [][]float64 {
 	points, _ := utils.ReadFromCsv("data/training.csv")
 
 	// Construct a forest of empty trees
-	token := InitForest(40, 256, nil, 3)
+	token, _ := InitForest(40, 256, nil, 3)
 
 	// For each training data point
 	for sampleIndex, point := range points {
@@ -80,9 +121,69 @@ func TrainingTrial() [][]float64 {
 	// For each streamed data point
 	for sampleIndex, point := range points {
 		// Update the forest with this point and record the average score
-		scores[sampleIndex] = UpdateForest(token, lastIndex+sampleIndex, point)
+		scores[sampleIndex], _ = UpdateForest(token, lastIndex+sampleIndex, point)
+	}
+
+	// Return points for plotting
+	return utils.GetDataPoints(points, scores, 0)
+}
+
+// WarmStartTrial shows how the forest can be primed with anomaly-free data in one call then continue streaming
+func WarmStartTrial() [][]float64 {
+	// Get sine function data for training
+	points, _ := utils.ReadFromCsv("data/training.csv")
+
+	// Construct a forest primed with the training data
+	token, _ := InitForestFromHistory(40, 256, points, 3)
+	lastIndex := len(points)
+
+	// Get sine function data with anomalies
+	points, _ = utils.ReadFromCsv("data/sine.csv")
+
+	// Create a map to store the anomaly score of each point
+	scores := make(map[int]float64)
+
+	// For each streamed data point
+	for sampleIndex, point := range points {
+		// Update the forest with this point and record the average score
+		scores[sampleIndex], _ = UpdateForest(token, lastIndex+sampleIndex, point)
 	}
 
 	// Return points for plotting
 	return utils.GetDataPoints(points, scores, 0)
 }
+
+// ForecastTrial shows how the forest can forecast streaming time series data one step ahead
+// The forest is trained with anomaly-free data, then forecasts each value of the data
+// with anomalies before the value is streamed
+// Returns each value with its forecast and the lower and upper bounds of the expected band
+func ForecastTrial() [][]float64 {
+	// Get sine function data for training
+	points, _ := utils.ReadFromCsv("data/training.csv")
+
+	// Construct a forest of empty trees, seeded so that the forecasts are repeatable
+	f, _ := forest.NewForest(40, 256, nil, 3, 0)
+
+	// For each training data point
+	for sampleIndex, point := range points {
+		// Update the forest with this point
+		f.Update(sampleIndex, point)
+	}
+	lastIndex := len(points)
+
+	// Get sine function data with anomalies
+	points, _ = utils.ReadFromCsv("data/sine.csv")
+
+	plotPoints := make([][]float64, len(points))
+
+	// For each streamed data point
+	for sampleIndex, point := range points {
+		// Forecast the point before updating the forest with it
+		plotPoints[sampleIndex] = []float64{point[0], 0, 0, 0}
+		if forecasts, err := f.Forecast(1); err == nil {
+			plotPoints[sampleIndex] = []float64{point[0], forecasts[0].Value, forecasts[0].Lower, forecasts[0].Upper}
+		}
+		f.Update(lastIndex+sampleIndex, point)
+	}
+	return plotPoints
+}